@@ -1,44 +1,335 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/export"
+	"github.com/context-labs/mactop/v2/helper"
+	"github.com/context-labs/mactop/v2/linux"
+	"github.com/context-labs/mactop/v2/runner"
 	"github.com/context-labs/mactop/v2/soc"
 	"github.com/context-labs/mactop/v2/ui"
+	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"os/signal"
+	"runtime"
+	"sync"
 	"syscall"
+	"time"
 )
 
-func Start(updateInterval int, colorName string) {
-	if os.Geteuid() != 0 {
-		fmt.Println("Welcome to mactop! Please try again and run mactop with sudo privileges!")
-		fmt.Println("Usage: sudo mactop")
-		return
+// geteuid is swapped out by tests.
+var geteuid = os.Geteuid
+
+type Options struct {
+	Color     string
+	Collector collector.Options
+
+	// Headless skips the terminal UI and writes every snapshot to Output as
+	// a line of JSON, see Sample. Output defaults to stdout; mactop serve
+	// passes io.Discard to only run the exporters.
+	Headless bool
+	Output   io.Writer
+	// Samples stops a headless run after this many snapshots, and
+	// Duration after this long. Zero runs until interrupted.
+	Samples  int
+	Duration time.Duration
+
+	// Export selects the sinks that receive every snapshot, next to the
+	// UI or headless output.
+	Export export.Options
+
+	// Runner starts sysctl, system_profiler and powermetrics. Nil uses
+	// runner.Default.
+	Runner runner.Runner
+
+	// HardwareCache is where detected hardware info is cached between
+	// runs. Empty turns the cache off. RefreshHardware detects the
+	// hardware again even if the cache is current, and Version is the
+	// mactop version the cache is tied to.
+	HardwareCache   string
+	RefreshHardware bool
+	Version         string
+
+	// Connect reads from the helper listening on this socket instead of
+	// running a collector. No root is needed.
+	Connect string
+	// Helper reads from a helper started with StartHelper before root was
+	// dropped.
+	Helper *Helper
+}
+
+// source is what the UI and headless mode read from: a local collector or
+// a connection to a helper.
+type source struct {
+	socInfo    *soc.SocInfo
+	opts       collector.Options
+	snapshots  *bus.Bus[collector.Snapshot]
+	health     *bus.Bus[collector.Health]
+	controller collector.Controller
+	history    *collector.History
+	run        func(done <-chan struct{})
+}
+
+// detect describes the machine. mactop runs with whatever could be
+// detected; the rest is shown as unknown.
+func detect(opts Options) *soc.SocInfo {
+	var info *soc.SocInfo
+	var err error
+	switch {
+	case opts.HardwareCache != "":
+		info, err = soc.CachedDetect(runner.OrDefault(opts.Runner), opts.HardwareCache, opts.Version, opts.RefreshHardware)
+	case opts.Runner == nil:
+		info, err = soc.GetSOCInfo()
+	default:
+		info, err = soc.Detect(opts.Runner)
+	}
+	if err != nil {
+		logrus.Warnf("hardware detection incomplete: %v", err)
+	}
+	return info
+}
+
+// detectLinux describes the machine from the device tree on Apple Silicon,
+// and otherwise from procfs, where only the CPU model and core count are
+// known.
+func detectLinux(root string) *soc.SocInfo {
+	if root == "" {
+		root = "/"
+	}
+	if info, err := soc.DetectDeviceTree(root); err == nil {
+		return info
+	}
+	fs := linux.FS{Root: root}
+	name, err := fs.ReadCPUModel()
+	if err != nil || name == "" {
+		name = "Linux"
+	}
+	cores := runtime.NumCPU()
+	if times, err := fs.ReadCPUTimes(); err == nil && len(times) > 0 {
+		cores = len(times)
+	}
+	memory, _ := fs.ReadMemory()
+	return &soc.SocInfo{
+		Name:        name,
+		CoreCount:   cores,
+		PCoreCount:  cores,
+		MemoryBytes: memory.Total,
+	}
+}
+
+// newCollector returns a source running a local collector.
+func newCollector(opts Options) source {
+	var socInfo *soc.SocInfo
+	if opts.Collector.Backend == collector.BackendLinux {
+		socInfo = detectLinux(opts.Collector.SysRoot)
+	} else {
+		socInfo = detect(opts)
+	}
+	opts.Collector.Runner = opts.Runner
+	metrics := collector.New(socInfo.Name, opts.Collector)
+	return source{
+		socInfo:    socInfo,
+		opts:       opts.Collector,
+		snapshots:  metrics.Snapshots,
+		health:     metrics.Health,
+		controller: metrics,
+		history:    metrics.History,
+		run:        metrics.Run,
+	}
+}
+
+func connect(path string) (source, error) {
+	client, err := helper.Dial(path)
+	if err != nil {
+		return source{}, err
+	}
+	return clientSource(client, nil), nil
+}
+
+// clientSource reads from a helper. stop, if set, is called once the
+// client stopped.
+func clientSource(client *helper.Client, stop func() error) source {
+	return source{
+		socInfo:    client.Hello.SocInfo,
+		opts:       client.Hello.CollectorOptions(),
+		snapshots:  client.Snapshots,
+		health:     client.Health,
+		controller: client,
+		history:    client.History,
+		run: func(done <-chan struct{}) {
+			if err := client.Run(done); err != nil {
+				logrus.Errorf("%v", err)
+			}
+			if stop != nil {
+				stop()
+			}
+		},
+	}
+}
+
+func Start(opts Options) error {
+	var src source
+	var err error
+	switch {
+	case opts.Helper != nil:
+		if src, err = opts.Helper.connect(opts); err != nil {
+			return err
+		}
+	case opts.Connect != "":
+		if src, err = connect(opts.Connect); err != nil {
+			return err
+		}
+	default:
+		// powermetrics needs root. Without it mactop still shows what any
+		// user can read.
+		if geteuid() != 0 && opts.Collector.Backend != collector.BackendLinux {
+			opts.Collector.Unprivileged = true
+		}
+		src = newCollector(opts)
 	}
 
-	cpuMetricsChan := make(chan parser.CPUMetrics)
-	gpuMetricsChan := make(chan parser.GPUMetrics)
-	netDiskMetricsChan := make(chan parser.NetDiskMetrics)
-	processMetricsChan := make(chan []parser.ProcessMetrics)
+	sinks, err := export.Open(opts.Export, src.socInfo)
+	if err != nil {
+		return err
+	}
+	exported := runSinks(src, sinks)
 
 	done := make(chan struct{})
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	if opts.Headless {
+		err := runHeadless(opts, src, done, quit)
+		// The snapshots end with the run, after which the sinks are
+		// closed.
+		<-exported
+		return err
+	}
 
-	appleSiliconModel := soc.GetSOCInfo()
-	go parser.CollectMetrics(done, cpuMetricsChan, gpuMetricsChan, netDiskMetricsChan, processMetricsChan, appleSiliconModel.Name, updateInterval)
+	snapshots := src.snapshots.Subscribe("ui", 4, bus.DropOldest)
+	health := src.health.Subscribe("ui", 4, bus.DropOldest)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		src.run(done)
+	}()
 
-	term := ui.NewUI(colorName,
-		updateInterval,
-		appleSiliconModel,
-		done,
+	term := ui.NewUI(opts.Color,
+		src.opts,
+		src.socInfo,
 		quit,
-		cpuMetricsChan,
-		gpuMetricsChan,
-		netDiskMetricsChan,
-		processMetricsChan,
+		snapshots,
+		health,
+		src.controller,
+		src.history,
 	)
 
 	term.Render()
+	// Stop powermetrics and let the sinks send what they buffered, as in
+	// headless mode.
+	close(done)
+	<-stopped
+	<-exported
+	return nil
+}
+
+// runSinks writes every snapshot to each sink until the snapshots end, then
+// closes the sinks. The returned channel is closed once they are.
+func runSinks(src source, sinks []export.Sink) <-chan struct{} {
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		snapshots := src.snapshots.Subscribe("export", 64, bus.DropOldest)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failing := false
+			for snapshot := range snapshots.C {
+				// Only the first of a run of errors is logged, so a
+				// full disk does not log every sample.
+				if err := sink.Write(&snapshot); err != nil {
+					if !failing {
+						logrus.Errorf("export: %v", err)
+					}
+					failing = true
+				} else {
+					failing = false
+				}
+			}
+			if err := sink.Close(); err != nil {
+				logrus.Errorf("export: %v", err)
+			}
+		}()
+	}
+	exported := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exported)
+	}()
+	return exported
+}
+
+// Sample is a line of headless output: a snapshot along with the machine
+// it was taken on.
+type Sample struct {
+	collector.Snapshot
+	SoC *soc.SocInfo
+}
+
+// runHeadless writes samples to opts.Output until enough were written, the
+// duration is over or a signal arrives, and waits for powermetrics to be
+// stopped before returning.
+func runHeadless(opts Options, src source, done chan struct{}, quit <-chan os.Signal) error {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	snapshots := src.snapshots.Subscribe("headless", 64, bus.DropOldest)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		src.run(done)
+	}()
+	stop := func() {
+		close(done)
+		<-stopped
+	}
+
+	var deadline <-chan time.Time
+	if opts.Duration > 0 {
+		timer := time.NewTimer(opts.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	encoder := json.NewEncoder(out)
+	count := 0
+	for {
+		select {
+		case snapshot, ok := <-snapshots.C:
+			if !ok {
+				return nil
+			}
+			if err := encoder.Encode(Sample{Snapshot: snapshot, SoC: src.socInfo}); err != nil {
+				stop()
+				return fmt.Errorf("failed to write snapshot: %w", err)
+			}
+			count++
+			if opts.Samples > 0 && count >= opts.Samples {
+				stop()
+				return nil
+			}
+		case <-deadline:
+			stop()
+			return nil
+		case <-quit:
+			stop()
+			return nil
+		}
+	}
 }
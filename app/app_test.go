@@ -0,0 +1,307 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/runner"
+	"github.com/context-labs/mactop/v2/testharness"
+)
+
+func init() {
+	geteuid = func() int { return 0 }
+}
+
+func testOptions(bin *testharness.Bin, samples int) Options {
+	collectorOpts := collector.DefaultOptions(50)
+	collectorOpts.MinBackoff = 10 * time.Millisecond
+	collectorOpts.MaxBackoff = 50 * time.Millisecond
+	collectorOpts.MinStallTimeout = 0
+	return Options{
+		Collector: collectorOpts,
+		Headless:  true,
+		Samples:   samples,
+		Runner:    bin.Runner(),
+	}
+}
+
+func runHeadlessTest(t *testing.T, opts Options) []Sample {
+	t.Helper()
+	var out bytes.Buffer
+	opts.Output = &out
+
+	errc := make(chan error, 1)
+	go func() { errc <- Start(opts) }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Start returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start did not return")
+	}
+
+	var snapshots []Sample
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var snapshot Sample
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			t.Fatalf("invalid snapshot %q: %v", scanner.Text(), err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func assertExited(t *testing.T, bin *testharness.Bin, name string) {
+	t.Helper()
+	for _, pid := range bin.Pids(name) {
+		if err := syscall.Kill(pid, 0); err == nil {
+			t.Errorf("%s (pid %d) is still running", name, pid)
+		}
+	}
+}
+
+func TestStartHeadless(t *testing.T) {
+	bin := testharness.MacBookPro(t, 50*time.Millisecond)
+
+	snapshots := runHeadlessTest(t, testOptions(bin, 3))
+
+	if len(snapshots) != 3 {
+		t.Fatalf("got %d snapshots, want 3", len(snapshots))
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.CPU.CPUW != 0.307 || snapshot.CPU.PackageW != 0.326 {
+			t.Errorf("got CPU %.3f W / package %.3f W, want 0.307 / 0.326", snapshot.CPU.CPUW, snapshot.CPU.PackageW)
+		}
+		if snapshot.GPU.Active != 2.62 {
+			t.Errorf("got GPU active %.2f, want 2.62", snapshot.GPU.Active)
+		}
+		if len(snapshot.Processes) != 3 {
+			t.Errorf("got %d processes, want 3", len(snapshot.Processes))
+		}
+		if snapshot.Health.State != collector.StateRunning {
+			t.Errorf("got health %s, want running", snapshot.Health.State)
+		}
+	}
+
+	calls := bin.Calls("powermetrics")
+	if len(calls) != 1 || !strings.Contains(calls[0], "--samplers cpu_power,gpu_power,thermal,network,disk") || !strings.HasSuffix(calls[0], "-i 50") {
+		t.Errorf("unexpected powermetrics invocations %q", calls)
+	}
+	if len(bin.Calls("sysctl")) != 1 || len(bin.Calls("system_profiler")) != 1 {
+		t.Errorf("expected sysctl and system_profiler to run once")
+	}
+	assertExited(t, bin, "powermetrics")
+}
+
+func TestStartHeadlessSample(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+
+	samples := runHeadlessTest(t, testOptions(bin, 1))
+	if len(samples) != 1 {
+		t.Fatalf("got %d samples, want 1", len(samples))
+	}
+	s := samples[0]
+	if s.SoC == nil || s.SoC.Name != "Apple M1 Pro" || s.SoC.PCoreCount != 8 {
+		t.Errorf("got soc info %+v, want an M1 Pro with 8 P-cores", s.SoC)
+	}
+	if s.ThermalPressure != "Nominal" {
+		t.Errorf("got thermal pressure %q, want Nominal", s.ThermalPressure)
+	}
+	if s.Time.IsZero() || s.CPU.CPUW == 0 {
+		t.Errorf("expected a timestamp and CPU power, got %+v", s.Snapshot)
+	}
+}
+
+func TestStartHeadlessDuration(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	opts := testOptions(bin, 0)
+	opts.Duration = 200 * time.Millisecond
+
+	start := time.Now()
+	samples := runHeadlessTest(t, opts)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %s, want about %s", elapsed, opts.Duration)
+	}
+	if len(samples) == 0 {
+		t.Error("got no samples")
+	}
+	assertExited(t, bin, "powermetrics")
+}
+
+func TestStartExportsCSV(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	opts := testOptions(bin, 3)
+	path := filepath.Join(t.TempDir(), "power.csv")
+	opts.Export.CSV.Path = path
+
+	runHeadlessTest(t, opts)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "time,") || !strings.Contains(lines[1], ",0.326,") {
+		t.Errorf("unexpected CSV %q", data)
+	}
+}
+
+func TestStartRestartsExitedPowermetrics(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	bin.Replay("powermetrics", testharness.Replay{
+		Fixture:  "powermetrics_m1pro.txt",
+		Count:    1,
+		Interval: 20 * time.Millisecond,
+		ExitCode: 1,
+	})
+
+	snapshots := runHeadlessTest(t, testOptions(bin, 3))
+
+	last := snapshots[len(snapshots)-1]
+	if last.Health.Restarts < 2 {
+		t.Errorf("got %d restarts, want at least 2", last.Health.Restarts)
+	}
+	if !strings.Contains(last.Health.LastError, "exit status 1") {
+		t.Errorf("got last error %q, want exit status 1", last.Health.LastError)
+	}
+	if n := len(bin.Pids("powermetrics")); n < 3 {
+		t.Errorf("powermetrics started %d times, want at least 3", n)
+	}
+	assertExited(t, bin, "powermetrics")
+}
+
+func TestStartRestartsStalledPowermetrics(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	bin.Script("powermetrics", "cat "+testharness.Fixture("powermetrics_m1pro.txt")+"\nexec sleep 60")
+
+	snapshots := runHeadlessTest(t, testOptions(bin, 2))
+
+	last := snapshots[len(snapshots)-1]
+	if last.Health.Restarts < 1 || !strings.Contains(last.Health.LastError, "stalled") {
+		t.Errorf("got health %+v, want a restart after a stall", last.Health)
+	}
+	assertExited(t, bin, "powermetrics")
+}
+
+func TestStartHeadlessWithoutRoot(t *testing.T) {
+	geteuid = func() int { return 501 }
+	defer func() { geteuid = func() int { return 0 } }()
+
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	snapshots := runHeadlessTest(t, testOptions(bin, 3))
+
+	if len(snapshots) != 3 {
+		t.Fatalf("got %d snapshots, want 3", len(snapshots))
+	}
+	if calls := bin.Calls("powermetrics"); len(calls) != 0 {
+		t.Errorf("powermetrics was started without root: %q", calls)
+	}
+	for _, s := range snapshots {
+		if s.Health.State != collector.StateUnavailable {
+			t.Errorf("got state %s, want unavailable", s.Health.State)
+		}
+		if len(s.CoreUsage) == 0 || s.Updated[collector.SourceCPU].IsZero() {
+			t.Error("expected per-core usage")
+		}
+		if !s.Updated[collector.SourcePower].IsZero() {
+			t.Error("expected no power samples")
+		}
+	}
+}
+
+func TestStartConnectsToHelper(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	socket := filepath.Join(t.TempDir(), "helper.sock")
+	stop := make(chan struct{})
+	helperErr := make(chan error, 1)
+	go func() {
+		helperErr <- RunHelper(HelperOptions{
+			Collector: testOptions(bin, 0).Collector,
+			Socket:    socket,
+			Mode:      0o600,
+			Gid:       -1,
+			Runner:    bin.Runner(),
+			Done:      stop,
+		})
+	}()
+
+	// Wait for the helper to listen.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("helper did not listen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	snapshots := runHeadlessTest(t, Options{Connect: socket, Headless: true, Samples: 2})
+	if len(snapshots) != 2 || snapshots[1].CPU.PackageW != 0.326 {
+		t.Fatalf("unexpected snapshots from the helper %+v", snapshots)
+	}
+
+	close(stop)
+	if err := <-helperErr; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bin.Calls("powermetrics")); n != 1 {
+		t.Errorf("powermetrics started %d times, want 1", n)
+	}
+	assertExited(t, bin, "powermetrics")
+}
+
+// TestHelperProcess is the root helper started by TestStartWithStdioHelper.
+func TestHelperProcess(t *testing.T) {
+	dir := os.Getenv("MACTOP_TEST_HELPER_BIN")
+	if dir == "" {
+		return
+	}
+	opts := HelperOptions{Collector: collector.DefaultOptions(100), Runner: runner.Exec{Dir: dir}}
+	if err := RunStdioHelper(os.Stdin, os.Stdout, opts); err != nil {
+		t.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+func TestStartWithStdioHelper(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "MACTOP_TEST_HELPER_BIN="+bin.Dir)
+	h, err := startHelper(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(bin, 2)
+	opts.Runner = nil
+	opts.Helper = h
+	opts.Collector.Interval = 250
+	opts.Collector.Samplers = []string{"cpu_power", "gpu_power"}
+	snapshots := runHeadlessTest(t, opts)
+
+	if len(snapshots) != 2 || snapshots[1].CPU.PackageW != 0.326 {
+		t.Fatalf("unexpected snapshots from the helper %+v", snapshots)
+	}
+	calls := bin.Calls("powermetrics")
+	if len(calls) != 1 || !strings.Contains(calls[0], "--samplers cpu_power,gpu_power ") || !strings.HasSuffix(calls[0], "-i 250") {
+		t.Errorf("expected the settings of the parent, got %q", calls)
+	}
+	if cmd.ProcessState == nil {
+		t.Error("expected the helper to have exited")
+	}
+	assertExited(t, bin, "powermetrics")
+}
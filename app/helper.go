@@ -0,0 +1,223 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/helper"
+	"github.com/context-labs/mactop/v2/runner"
+	"github.com/sirupsen/logrus"
+)
+
+type HelperOptions struct {
+	Collector collector.Options
+	// Socket is where clients connect. Empty uses helper.DefaultSocket.
+	Socket string
+	// Mode and Gid control who may connect. A Gid of -1 keeps the group of
+	// the helper.
+	Mode fs.FileMode
+	Gid  int
+	// AllowSetInterval lets clients change the interval of the shared
+	// collector, for every other client too.
+	AllowSetInterval bool
+
+	// Runner starts sysctl, system_profiler and powermetrics. Nil uses
+	// runner.Default.
+	Runner runner.Runner
+	// HardwareCache, RefreshHardware and Version control the hardware
+	// info cache, see Options.
+	HardwareCache   string
+	RefreshHardware bool
+	Version         string
+	// Done stops the helper when closed. Nil stops it on SIGINT or
+	// SIGTERM.
+	Done <-chan struct{}
+}
+
+// options returns the Options of a collector run by the helper.
+func (opts HelperOptions) options(collectorOpts collector.Options) Options {
+	return Options{
+		Collector:       collectorOpts,
+		Runner:          opts.Runner,
+		HardwareCache:   opts.HardwareCache,
+		RefreshHardware: opts.RefreshHardware,
+		Version:         opts.Version,
+	}
+}
+
+// RunHelper runs a single collector as root and serves its snapshots to
+// every client connecting to the socket, until stopped.
+func RunHelper(opts HelperOptions) error {
+	if geteuid() != 0 {
+		return errors.New("mactop helperd must run as root, for example with sudo")
+	}
+	socket := opts.Socket
+	if socket == "" {
+		socket = helper.DefaultSocket
+	}
+
+	l, err := helper.Listen(socket, opts.Mode, opts.Gid)
+	if err != nil {
+		return err
+	}
+
+	stop := opts.Done
+	if stop == nil {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(quit)
+		ch := make(chan struct{})
+		go func() {
+			<-quit
+			close(ch)
+		}()
+		stop = ch
+	}
+
+	src := newCollector(opts.options(opts.Collector))
+	server := &helper.Server{
+		Hello:            helper.NewHello(src.socInfo, src.opts),
+		Snapshots:        src.snapshots,
+		Health:           src.health,
+		Controller:       src.controller,
+		AllowSetInterval: opts.AllowSetInterval,
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		src.run(done)
+	}()
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() { close(done) })
+	}
+	go func() {
+		<-stop
+		shutdown()
+	}()
+
+	logrus.Infof("helper listening on %s", socket)
+	err = server.Serve(l, done)
+	shutdown()
+	<-stopped
+	return err
+}
+
+// Helper is a root helper process talking over its stdin and stdout. It is
+// started before mactop drops root so powermetrics can still be started and
+// restarted afterwards, while everything else runs as the invoking user.
+type Helper struct {
+	cmd    *exec.Cmd
+	stdout io.Reader
+	conn   *helper.Stream
+}
+
+// StartHelper starts "mactop helperd --stdio" from the running executable.
+func StartHelper() (*Helper, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the mactop executable: %w", err)
+	}
+	return startHelper(exec.Command(exe, "helperd", "--stdio"))
+}
+
+func startHelper(cmd *exec.Cmd) (*Helper, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start helper: %w", err)
+	}
+	// Closing stdin stops the helper, which then closes stdout.
+	return &Helper{
+		cmd:    cmd,
+		stdout: stdout,
+		conn:   &helper.Stream{Reader: stdout, Writer: stdin, Closers: []io.Closer{stdin}},
+	}, nil
+}
+
+// connect sends the settings the helper waits for and returns it as a
+// source.
+func (h *Helper) connect(opts Options) (source, error) {
+	settings := helper.NewSettings(opts.Collector)
+	settings.RefreshHardware = opts.RefreshHardware
+	if err := helper.Configure(h.conn, settings); err != nil {
+		h.Close()
+		return source{}, fmt.Errorf("failed to configure helper: %w", err)
+	}
+	client, err := helper.NewClient(h.conn)
+	if err != nil {
+		h.Close()
+		return source{}, err
+	}
+	return clientSource(client, h.Close), nil
+}
+
+// Close stops the helper, which stops powermetrics, and waits for it to
+// exit.
+func (h *Helper) Close() error {
+	h.conn.Close()
+	io.Copy(io.Discard, h.stdout)
+	return h.cmd.Wait()
+}
+
+// RunStdioHelper is the helper StartHelper runs. It waits for the settings
+// of its parent on in, then collects and serves the parent over in and out
+// until the parent goes away.
+func RunStdioHelper(in io.Reader, out io.WriteCloser, opts HelperOptions) error {
+	if geteuid() != 0 {
+		return errors.New("mactop helperd must run as root, for example with sudo")
+	}
+	// Interrupts from the terminal reach the whole process group. The
+	// parent decides when to stop by closing in, and a parent that went
+	// away must not kill the helper before it stopped powermetrics.
+	signal.Ignore(os.Interrupt, syscall.SIGTERM, syscall.SIGPIPE)
+
+	r := bufio.NewReader(in)
+	settings, err := helper.ReadSettings(r)
+	if err != nil {
+		return err
+	}
+	collectorOpts := settings.Apply(opts.Collector)
+	if err := collectorOpts.Validate(); err != nil {
+		return err
+	}
+
+	opts.RefreshHardware = opts.RefreshHardware || settings.RefreshHardware
+	src := newCollector(opts.options(collectorOpts))
+	// The only client is the mactop that started the helper.
+	server := &helper.Server{
+		Hello:            helper.NewHello(src.socInfo, src.opts),
+		Snapshots:        src.snapshots,
+		Health:           src.health,
+		Controller:       src.controller,
+		AllowSetInterval: true,
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		src.run(done)
+	}()
+	server.ServeStream(&helper.Stream{Reader: r, Writer: out, Closers: []io.Closer{out}}, done)
+	close(done)
+	<-stopped
+	return nil
+}
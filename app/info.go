@@ -0,0 +1,221 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/context-labs/mactop/v2/runner"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+// Info is everything mactop knows about the machine, as printed by
+// mactop info.
+type Info struct {
+	SoC *soc.SocInfo `json:"soc"`
+	// Spec is the entry of the spec table the limits in SoC come from.
+	Spec *soc.Spec `json:"spec,omitempty"`
+	OS   OSInfo    `json:"os"`
+	// Powermetrics is only set on macOS.
+	Powermetrics *PowermetricsInfo `json:"powermetrics,omitempty"`
+	Battery      bool              `json:"battery"`
+	// Warnings lists what could not be detected.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type OSInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Build   string `json:"build,omitempty"`
+}
+
+type PowermetricsInfo struct {
+	// Version is the build of macOS powermetrics ships with; it has no
+	// version of its own.
+	Version  string   `json:"version"`
+	Samplers []string `json:"samplers"`
+}
+
+// CollectInfo describes the machine mactop runs on. r runs the macOS
+// tools; nil uses runner.Default.
+func CollectInfo(r runner.Runner) Info {
+	if runtime.GOOS == "linux" {
+		return collectLinuxInfo("/")
+	}
+	return collectInfo(runner.OrDefault(r))
+}
+
+func collectInfo(r runner.Runner) Info {
+	var info Info
+	var err error
+	info.SoC, err = soc.Detect(r)
+	info.warn(err)
+
+	info.OS.Name = "macOS"
+	info.OS.Version, err = output(r, "sw_vers", "-productVersion")
+	info.warn(err)
+	info.OS.Build, err = output(r, "sw_vers", "-buildVersion")
+	info.warn(err)
+
+	// powermetrics prints its usage even when it refuses to run without
+	// root.
+	usage, _ := r.Command("powermetrics", "-h").CombinedOutput()
+	if samplers := parseSamplers(usage); len(samplers) > 0 {
+		info.Powermetrics = &PowermetricsInfo{Version: info.OS.Build, Samplers: samplers}
+	} else {
+		info.warn(fmt.Errorf("powermetrics did not list its samplers"))
+	}
+
+	batt, err := output(r, "pmset", "-g", "batt")
+	info.warn(err)
+	info.Battery = strings.Contains(batt, "InternalBattery")
+
+	info.lookupSpec()
+	return info
+}
+
+// collectLinuxInfo describes a Linux machine from procfs and sysfs below
+// root.
+func collectLinuxInfo(root string) Info {
+	var info Info
+	info.SoC = detectLinux(root)
+	info.OS.Name = "Linux"
+	if release, err := os.ReadFile(filepath.Join(root, "proc", "sys", "kernel", "osrelease")); err == nil {
+		info.OS.Version = strings.TrimSpace(string(release))
+	}
+	if osRelease, err := os.ReadFile(filepath.Join(root, "etc", "os-release")); err == nil {
+		for _, line := range strings.Split(string(osRelease), "\n") {
+			if name, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				info.OS.Name = strings.Trim(name, `"`)
+			}
+		}
+	}
+	supplies, _ := filepath.Glob(filepath.Join(root, "sys", "class", "power_supply", "BAT*"))
+	info.Battery = len(supplies) > 0
+	info.lookupSpec()
+	return info
+}
+
+func (info *Info) lookupSpec() {
+	s := info.SoC
+	if spec, ok := soc.LookupSpec(s.Name, s.ECoreCount, s.PCoreCount, s.GpuCoreCount); ok {
+		info.Spec = &spec
+	}
+}
+
+// warn records every line of err.
+func (info *Info) warn(err error) {
+	if err != nil {
+		info.Warnings = append(info.Warnings, strings.Split(err.Error(), "\n")...)
+	}
+}
+
+func output(r runner.Runner, name string, args ...string) (string, error) {
+	out, err := r.Command(name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseSamplers reads the sampler names from the "Samplers:" section of
+// the powermetrics usage.
+func parseSamplers(usage []byte) []string {
+	var samplers []string
+	inSection := false
+	scanner := bufio.NewScanner(bytes.NewReader(usage))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "Samplers:" {
+			inSection = true
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			break
+		}
+		samplers = append(samplers, fields[0])
+	}
+	return samplers
+}
+
+// WriteText writes the info as aligned, human readable lines.
+func (info Info) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(label, format string, args ...any) {
+		fmt.Fprintf(tw, "%s:\t%s\n", label, fmt.Sprintf(format, args...))
+	}
+	s := info.SoC
+	line("Chip", "%s", orUnknown(s.Name))
+	if s.MachineName != "" {
+		line("Machine", "%s (%s)", s.MachineName, s.ModelIdentifier)
+	}
+	line("CPU cores", "%d (%d E + %d P)", s.CoreCount, s.ECoreCount, s.PCoreCount)
+	gpu := "unknown"
+	if s.GpuCoreCount > 0 {
+		gpu = fmt.Sprint(s.GpuCoreCount)
+	}
+	if s.MetalFamily != "" {
+		gpu += ", " + s.MetalFamily
+	}
+	line("GPU cores", "%s", gpu)
+	memory := "unknown"
+	if s.MemoryBytes > 0 {
+		memory = fmt.Sprintf("%d GB", (s.MemoryBytes+1<<29)>>30)
+		if s.MemoryType != "" {
+			memory += " " + s.MemoryType
+		}
+	}
+	line("Memory", "%s", memory)
+	system := info.OS.Name + " " + info.OS.Version
+	if info.OS.Build != "" {
+		system += " (" + info.OS.Build + ")"
+	}
+	line("OS", "%s", strings.TrimSpace(system))
+	if p := info.Powermetrics; p != nil {
+		line("powermetrics", "build %s; samplers %s", p.Version, strings.Join(p.Samplers, ", "))
+	}
+	line("Battery", "%s", map[bool]string{true: "yes", false: "no"}[info.Battery])
+	if spec := info.Spec; spec != nil {
+		var config []string
+		for _, c := range []struct {
+			n    int
+			kind string
+		}{{spec.ECores, "E-cores"}, {spec.PCores, "P-cores"}, {spec.GPUCores, "GPU cores"}} {
+			if c.n > 0 {
+				config = append(config, fmt.Sprintf("%d %s", c.n, c.kind))
+			}
+		}
+		profile := spec.Name
+		if len(config) > 0 {
+			profile += " with " + strings.Join(config, ", ")
+		}
+		line("Spec profile", "%s", profile)
+		line("Max power", "CPU %g W, GPU %g W, ANE %g W", spec.CpuMaxPower, spec.GpuMaxPower, spec.AneMaxPower)
+		line("Bandwidth", "memory %g GB/s, CPU %g GB/s, GPU %g GB/s", spec.MemoryBw, spec.CpuMaxBw, spec.GpuMaxBw)
+		line("Max frequency", "E %d MHz, P %d MHz", spec.MaxEFreqMHz, spec.MaxPFreqMHz)
+		line("ANE", "%g TOPS", spec.AneTOPS)
+	} else {
+		line("Spec profile", "none, limits unknown")
+	}
+	for _, w := range info.Warnings {
+		line("Warning", "%s", w)
+	}
+	return tw.Flush()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
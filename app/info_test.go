@@ -0,0 +1,69 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/context-labs/mactop/v2/testharness"
+)
+
+func TestCollectInfo(t *testing.T) {
+	bin := testharness.NewBin(t)
+	bin.Output("sysctl", "sysctl_m1pro.txt")
+	bin.Output("system_profiler", "system_profiler_m1pro.json")
+	bin.Script("sw_vers", `case "$1" in -productVersion) echo 14.5 ;; -buildVersion) echo 23F79 ;; esac`)
+	// Without root powermetrics prints its usage and fails.
+	bin.Script("powermetrics", fmt.Sprintf("cat %q; exit 1", testharness.Fixture("powermetrics_usage.txt")))
+	bin.Script("pmset", `echo "Now drawing from 'AC Power'"; echo " -InternalBattery-0 (id=12345)	100%; charged; 0:00 remaining present: true"`)
+
+	info := collectInfo(bin.Runner())
+	if len(info.Warnings) > 0 {
+		t.Errorf("unexpected warnings %v", info.Warnings)
+	}
+	if info.SoC.Name != "Apple M1 Pro" || info.OS.Version != "14.5" || info.OS.Build != "23F79" || !info.Battery {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if info.Powermetrics == nil || !slices.Contains(info.Powermetrics.Samplers, "ane_power") || len(info.Powermetrics.Samplers) != 10 {
+		t.Errorf("unexpected powermetrics info %+v", info.Powermetrics)
+	}
+	if info.Spec == nil || info.Spec.Name != "Apple M1 Pro" || info.Spec.PCores != 0 {
+		t.Errorf("expected the generic M1 Pro spec, got %+v", info.Spec)
+	}
+
+	var text bytes.Buffer
+	if err := info.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Apple M1 Pro", "MacBook Pro (MacBookPro18,3)", "10 (2 E + 8 P)", "16, Metal 3", "32 GB LPDDR5", "macOS 14.5 (23F79)", "build 23F79; samplers tasks, battery", "200 GB/s"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("expected %q in\n%s", want, text.String())
+		}
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "XXXXXXXXXX") {
+		t.Error("the serial number must not be reported")
+	}
+}
+
+func TestCollectInfoWarnings(t *testing.T) {
+	bin := testharness.NewBin(t)
+	for _, name := range []string{"sysctl", "system_profiler", "sw_vers", "powermetrics", "pmset"} {
+		bin.Script(name, "exit 1")
+	}
+	info := collectInfo(bin.Runner())
+	if info.SoC == nil || len(info.Warnings) < 5 || info.Spec != nil {
+		t.Errorf("expected partial info with warnings, got %+v", info)
+	}
+	var text bytes.Buffer
+	if err := info.WriteText(&text); err != nil || !strings.Contains(text.String(), "Chip:") {
+		t.Errorf("expected text output, got %q, %v", text.String(), err)
+	}
+}
@@ -0,0 +1,164 @@
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Policy decides what happens when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered value to make room.
+	DropOldest Policy = iota
+	// DropNewest discards the value being published.
+	DropNewest
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	}
+	return "unknown"
+}
+
+// Bus fans every published value out to any number of subscribers. Publish
+// never blocks: each subscriber has its own buffer and a slow subscriber only
+// loses its own values.
+type Bus[T any] struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription[T]]struct{}
+	closed bool
+}
+
+func New[T any]() *Bus[T] {
+	return &Bus[T]{subs: make(map[*Subscription[T]]struct{})}
+}
+
+type Subscription[T any] struct {
+	// C receives published values. It is closed when the subscription is
+	// cancelled or the bus is closed.
+	C <-chan T
+
+	name    string
+	policy  Policy
+	ch      chan T
+	bus     *Bus[T]
+	dropped atomic.Uint64
+}
+
+// Stats describes a single subscriber.
+type Stats struct {
+	Name     string
+	Policy   Policy
+	Buffered int
+	Capacity int
+	Dropped  uint64
+}
+
+// Subscribe attaches a new subscriber with a buffer of size values. The
+// name only shows up in Stats.
+func (b *Bus[T]) Subscribe(name string, size int, policy Policy) *Subscription[T] {
+	ch := make(chan T, max(size, 1))
+	sub := &Subscription[T]{
+		C:      ch,
+		name:   name,
+		policy: policy,
+		ch:     ch,
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers v to every subscriber without blocking.
+func (b *Bus[T]) Publish(v T) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		sub.deliver(v)
+	}
+}
+
+// Close closes every subscription. Publishing after Close is a no-op.
+func (b *Bus[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.ch)
+		delete(b.subs, sub)
+	}
+}
+
+// Stats returns the state of every current subscriber.
+func (b *Bus[T]) Stats() []Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := make([]Stats, 0, len(b.subs))
+	for sub := range b.subs {
+		stats = append(stats, sub.Stats())
+	}
+	return stats
+}
+
+func (s *Subscription[T]) deliver(v T) {
+	for {
+		select {
+		case s.ch <- v:
+			return
+		default:
+		}
+
+		if s.policy == DropNewest {
+			s.dropped.Add(1)
+			return
+		}
+
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of values this subscriber lost because its
+// buffer was full.
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription[T]) Stats() Stats {
+	return Stats{
+		Name:     s.name,
+		Policy:   s.policy,
+		Buffered: len(s.ch),
+		Capacity: cap(s.ch),
+		Dropped:  s.Dropped(),
+	}
+}
+
+// Unsubscribe detaches the subscriber and closes C.
+func (s *Subscription[T]) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; !ok {
+		return
+	}
+	delete(s.bus.subs, s)
+	close(s.ch)
+}
@@ -0,0 +1,65 @@
+package bus
+
+import "testing"
+
+func TestPublishFansOut(t *testing.T) {
+	b := New[int]()
+	first := b.Subscribe("first", 4, DropOldest)
+	second := b.Subscribe("second", 4, DropOldest)
+
+	b.Publish(1)
+	b.Publish(2)
+
+	for _, sub := range []*Subscription[int]{first, second} {
+		if v := <-sub.C; v != 1 {
+			t.Errorf("%s: got %d, want 1", sub.name, v)
+		}
+		if v := <-sub.C; v != 2 {
+			t.Errorf("%s: got %d, want 2", sub.name, v)
+		}
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	b := New[int]()
+	oldest := b.Subscribe("oldest", 2, DropOldest)
+	newest := b.Subscribe("newest", 2, DropNewest)
+
+	for i := 1; i <= 5; i++ {
+		b.Publish(i)
+	}
+
+	if got := []int{<-oldest.C, <-oldest.C}; got[0] != 4 || got[1] != 5 {
+		t.Errorf("drop-oldest kept %v, want [4 5]", got)
+	}
+	if got := []int{<-newest.C, <-newest.C}; got[0] != 1 || got[1] != 2 {
+		t.Errorf("drop-newest kept %v, want [1 2]", got)
+	}
+	if oldest.Dropped() != 3 || newest.Dropped() != 3 {
+		t.Errorf("dropped = %d/%d, want 3/3", oldest.Dropped(), newest.Dropped())
+	}
+}
+
+func TestUnsubscribeAndClose(t *testing.T) {
+	b := New[int]()
+	gone := b.Subscribe("gone", 1, DropOldest)
+	kept := b.Subscribe("kept", 1, DropOldest)
+
+	gone.Unsubscribe()
+	gone.Unsubscribe()
+	if _, ok := <-gone.C; ok {
+		t.Fatal("expected closed channel after Unsubscribe")
+	}
+	if n := len(b.Stats()); n != 1 {
+		t.Fatalf("got %d subscribers, want 1", n)
+	}
+
+	b.Close()
+	b.Publish(1)
+	if _, ok := <-kept.C; ok {
+		t.Fatal("expected closed channel after Close")
+	}
+	if _, ok := <-b.Subscribe("late", 1, DropOldest).C; ok {
+		t.Fatal("expected subscription on closed bus to be closed")
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/context-labs/mactop/v2/app"
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/helper"
+	"github.com/context-labs/mactop/v2/soc"
+	"github.com/spf13/cobra"
+)
+
+var helperSocket string
+var helperGroup string
+var helperMode string
+var helperStdio bool
+var helperAllowInterval bool
+
+var helperdCmd = &cobra.Command{
+	Use:   "helperd",
+	Short: "Run powermetrics as root and share its samples on a Unix socket",
+	Long: `helperd runs and supervises a single powermetrics and publishes its
+samples on a Unix domain socket. Connect to it without sudo using
+mactop --connect <socket>.`,
+	RunE: func(c *cobra.Command, args []string) error {
+		if helperStdio {
+			// Settings come from the parent, which already dropped root
+			// and read the config file as the invoking user.
+			return app.RunStdioHelper(os.Stdin, os.Stdout, app.HelperOptions{
+				Collector:     collector.DefaultOptions(updateInterval),
+				HardwareCache: soc.HelperCachePath(),
+				Version:       version,
+			})
+		}
+		opts, err := loadOptions(c)
+		if err != nil {
+			return err
+		}
+		mode, err := strconv.ParseUint(helperMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode %q: %w", helperMode, err)
+		}
+		gid := -1
+		if helperGroup != "" {
+			group, err := user.LookupGroup(helperGroup)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(group.Gid); err != nil {
+				return fmt.Errorf("invalid gid %q for group %s", group.Gid, helperGroup)
+			}
+		}
+		return app.RunHelper(app.HelperOptions{
+			Collector:        opts.Collector,
+			Socket:           helperSocket,
+			Mode:             os.FileMode(mode),
+			Gid:              gid,
+			AllowSetInterval: helperAllowInterval,
+			HardwareCache:    soc.HelperCachePath(),
+			RefreshHardware:  refreshHardware,
+			Version:          version,
+		})
+	},
+}
+
+func init() {
+	helperdCmd.Flags().StringVar(&helperSocket, "socket", helper.DefaultSocket, "path of the Unix socket clients connect to")
+	helperdCmd.Flags().StringVar(&helperGroup, "group", "", "group allowed to connect, in addition to root")
+	helperdCmd.Flags().StringVar(&helperMode, "mode", "0660", "permissions of the socket, in octal")
+	helperdCmd.Flags().BoolVar(&helperAllowInterval, "allow-interval-changes", false, "let connected clients change the sample interval for every client")
+	helperdCmd.Flags().BoolVar(&helperStdio, "stdio", false, "serve a single client over stdin and stdout instead of a socket")
+	helperdCmd.Flags().MarkHidden("stdio")
+	rootCmd.AddCommand(helperdCmd)
+}
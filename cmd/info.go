@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/context-labs/mactop/v2/app"
+	"github.com/spf13/cobra"
+)
+
+var infoJSON bool
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Print what mactop knows about this machine",
+	Long: `info prints the chip, core topology, memory, OS, powermetrics samplers,
+battery and the chip limits mactop uses, without starting the UI.`,
+	RunE: func(c *cobra.Command, args []string) error {
+		info := app.CollectInfo(nil)
+		if infoJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(info)
+		}
+		return info.WriteText(os.Stdout)
+	},
+}
+
+func init() {
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "print the info as JSON")
+	rootCmd.AddCommand(infoCmd)
+}
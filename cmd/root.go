@@ -1,30 +1,218 @@
 package cmd
 
 import (
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
 	"github.com/context-labs/mactop/v2/app"
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/config"
+	"github.com/context-labs/mactop/v2/export"
+	"github.com/context-labs/mactop/v2/privileges"
+	"github.com/context-labs/mactop/v2/soc"
 	"github.com/spf13/cobra"
 )
 
 var showVersion bool
 var colorName string
 var updateInterval int
+var configPath string
+var samplers string
+var processStats string
+var unprivileged bool
+var connectSocket string
+var backend string
+var sysRoot string
+var refreshHardware bool
+var headless bool
+var output io.Writer
+var samples int
+var duration time.Duration
+var csvOpts export.CSVOptions
+var csvRotateSizeMB int64
+var prometheusOpts export.PrometheusOptions
+var influxOpts export.InfluxOptions
+var statsdOpts export.StatsDOptions
+var otlpOpts export.OTLPOptions
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "v", false, "show version of mactop")
 	rootCmd.PersistentFlags().StringVarP(&colorName, "color", "c", "white", "set the UI color. Default is white. Options are 'green', 'red', 'blue', 'cyan', 'magenta', 'yellow', and 'white'.")
 	rootCmd.PersistentFlags().IntVarP(&updateInterval, "interval", "i", 1000, "set the powermetrics update interval in milliseconds")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "path to the config file (default "+config.DefaultPath()+")")
+	rootCmd.PersistentFlags().StringVar(&samplers, "samplers", strings.Join(collector.DefaultSamplers, ","), "comma separated powermetrics samplers. Options are "+strings.Join(collector.Samplers, ", "))
+	rootCmd.PersistentFlags().StringVar(&processStats, "process-stats", strings.Join(collector.DefaultProcessStats, ","), "comma separated per-process statistics, or 'none' to turn off process sampling. Options are gpu, energy, netstats, io, coalition")
+	rootCmd.Flags().StringVar(&connectSocket, "connect", "", "read from the mactop helperd listening on this socket instead of running powermetrics")
+	rootCmd.PersistentFlags().StringVar(&backend, "backend", defaultBackend(), "where metrics come from. Options are "+strings.Join(collector.Backends, ", "))
+	rootCmd.PersistentFlags().StringVar(&sysRoot, "sys-root", "/", "root of the /proc and /sys trees read by the linux backend")
+	rootCmd.PersistentFlags().BoolVar(&refreshHardware, "refresh-hardware", false, "detect the hardware again instead of using the cached result")
+	rootCmd.PersistentFlags().BoolVar(&unprivileged, "unprivileged", false, "run without powermetrics, as mactop does when started without sudo")
+	rootCmd.Flags().BoolVar(&headless, "json", false, "write one JSON object per sample to stdout instead of starting the UI")
+	addStreamFlags(rootCmd)
+	addExportFlags(rootCmd)
+}
+
+// addExportFlags adds the flags of the sinks that run next to the UI or
+// headless output.
+func addExportFlags(c *cobra.Command) {
+	c.Flags().StringVar(&csvOpts.Path, "csv", "", "also write a CSV row per sample to this file")
+	c.Flags().StringVar(&csvOpts.ProcessPath, "csv-processes", "", "also write the process table of every sample to this CSV file")
+	c.Flags().Int64Var(&csvRotateSizeMB, "csv-rotate-size", 0, "start a new CSV file once the current one reaches this many megabytes")
+	c.Flags().DurationVar(&csvOpts.MaxAge, "csv-rotate-interval", 0, "start a new CSV file after this long, e.g. 1h")
+	c.Flags().StringVar(&prometheusOpts.Listen, "prometheus", "", "also serve Prometheus metrics at /metrics on this address, e.g. :9100")
+	c.Flags().IntVar(&prometheusOpts.Processes, "prometheus-processes", 0, "expose the CPU time of the top this many processes to Prometheus")
+	c.Flags().StringVar(&influxOpts.Target, "influx", "", "also write InfluxDB line protocol to this URL, file, or - for stdout, e.g. http://localhost:8086?org=lab&bucket=mactop")
+	c.Flags().StringVar(&influxOpts.Token, "influx-token", "", "InfluxDB API token (default $INFLUX_TOKEN)")
+	c.Flags().StringVar(&statsdOpts.Addr, "statsd", "", "also send gauges to the StatsD agent at this host:port, e.g. localhost:8125")
+	c.Flags().StringVar(&statsdOpts.Prefix, "statsd-prefix", "mactop.", "prefix of the StatsD metric names")
+	c.Flags().BoolVar(&statsdOpts.Tags, "statsd-tags", false, "send DogStatsD tags for the chip, host, cluster and core")
+	c.Flags().StringVar(&otlpOpts.Endpoint, "otlp", "", "also export OTLP/HTTP JSON metrics to this collector, e.g. http://localhost:4318")
+	c.Flags().StringToStringVar(&otlpOpts.Headers, "otlp-header", nil, "header sent with every OTLP request, as key=value")
+	c.Flags().DurationVar(&otlpOpts.Interval, "otlp-interval", 10*time.Second, "how often to export OTLP metrics")
+}
+
+// addStreamFlags adds the flags that limit a headless run.
+func addStreamFlags(c *cobra.Command) {
+	c.Flags().IntVar(&samples, "samples", 0, "stop streaming JSON after this many samples")
+	c.Flags().DurationVar(&duration, "duration", 0, "stop streaming JSON after this long, e.g. 30s or 5m")
 }
 
 var rootCmd = &cobra.Command{
 	Use: "mactop",
-	Long: `You must use sudo to run mactop, as powermetrics requires root privileges.
+	Long: `sudo is only needed for power, frequency, GPU and ANE metrics, which come
+from powermetrics. Without it mactop shows CPU usage, memory, network, disk
+and processes.
 For more information, see https://github.com/context-labs/mactop
 `,
-	Run: func(c *cobra.Command, args []string) {
-		app.Start(updateInterval, colorName)
+	RunE: func(c *cobra.Command, args []string) error {
+		return run(c)
 	},
 }
 
+// run starts mactop with the UI, or headless with --json and stream.
+func run(c *cobra.Command) error {
+	uid, gid, sudo := privileges.SudoUser()
+	if sudo {
+		privileges.SetUserEnv(uid)
+	}
+	// Flags and the config file are checked before a helper is started,
+	// so a mistake never starts powermetrics as root.
+	opts, err := loadOptions(c)
+	if err != nil {
+		return err
+	}
+	if !sudo {
+		return app.Start(opts)
+	}
+
+	// Under sudo, only a helper running the collector keeps root, and only
+	// when the collector needs it. The rest of mactop runs as the user who
+	// invoked sudo.
+	if opts.Connect == "" && opts.Collector.NeedsRoot() {
+		if opts.Helper, err = app.StartHelper(); err != nil {
+			return err
+		}
+	}
+	if err := privileges.Drop(uid, gid); err != nil {
+		if opts.Helper != nil {
+			opts.Helper.Close()
+		}
+		return err
+	}
+	return app.Start(opts)
+}
+
+// loadOptions merges the config file with the command line. Flags that were
+// set explicitly win over the config file.
+func loadOptions(c *cobra.Command) (app.Options, error) {
+	path := configPath
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	cfg, err := config.Load(path, configPath != "")
+	if err != nil {
+		return app.Options{}, err
+	}
+
+	flags := c.Flags()
+	if cfg.Color != "" && !flags.Changed("color") {
+		colorName = cfg.Color
+	}
+	if cfg.Interval != 0 && !flags.Changed("interval") {
+		updateInterval = cfg.Interval
+	}
+
+	collectorOpts := collector.DefaultOptions(updateInterval)
+	collectorOpts.Samplers = splitList(samplers)
+	if cfg.Samplers != nil && !flags.Changed("samplers") {
+		collectorOpts.Samplers = cfg.Samplers
+	}
+	collectorOpts.ProcessStats = splitList(processStats)
+	if cfg.ProcessStats != nil && !flags.Changed("process-stats") {
+		collectorOpts.ProcessStats = cfg.ProcessStats
+	}
+	collectorOpts.Unprivileged = unprivileged
+	collectorOpts.Backend = backend
+	if cfg.Backend != "" && !flags.Changed("backend") {
+		collectorOpts.Backend = cfg.Backend
+	}
+	collectorOpts.SysRoot = sysRoot
+	for _, d := range cfg.Derived {
+		collectorOpts.Derived = append(collectorOpts.Derived, collector.Derived(d))
+	}
+	if err := collectorOpts.Validate(); err != nil {
+		return app.Options{}, err
+	}
+
+	exportOpts := export.Options{CSV: csvOpts, Prometheus: prometheusOpts, Influx: influxOpts, StatsD: statsdOpts, OTLP: otlpOpts, Version: version}
+	if exportOpts.Influx.Token == "" {
+		// Read here rather than as the flag default, which --help prints.
+		exportOpts.Influx.Token = os.Getenv("INFLUX_TOKEN")
+	}
+	exportOpts.CSV.MaxSize = csvRotateSizeMB << 20
+	for _, d := range collectorOpts.Derived {
+		exportOpts.Derived = append(exportOpts.Derived, d.Name)
+	}
+
+	return app.Options{
+		Color:     colorName,
+		Collector: collectorOpts,
+		Connect:   connectSocket,
+		Headless:  headless,
+		Output:    output,
+		Samples:   samples,
+		Duration:  duration,
+		Export:    exportOpts,
+
+		HardwareCache:   soc.DefaultCachePath(),
+		RefreshHardware: refreshHardware,
+		Version:         version,
+	}, nil
+}
+
+// defaultBackend picks the backend of the running system.
+func defaultBackend() string {
+	if runtime.GOOS == "linux" {
+		return collector.BackendLinux
+	}
+	return collector.BackendPowermetrics
+}
+
+// splitList splits a comma separated flag value. "none" yields an empty list.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && item != "none" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func Execute() error {
 	return rootCmd.Execute()
 }
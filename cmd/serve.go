@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+)
+
+var serveListen string
+var serveProcesses int
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve Prometheus metrics without the UI",
+	Long: `serve skips the UI and exposes every metric at /metrics in the Prometheus
+text format, or in OpenMetrics when the scraper asks for it.`,
+	RunE: func(c *cobra.Command, args []string) error {
+		headless = true
+		output = io.Discard
+		prometheusOpts.Listen = serveListen
+		prometheusOpts.Processes = serveProcesses
+		return run(c)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":9100", "address to serve /metrics on")
+	serveCmd.Flags().IntVar(&serveProcesses, "processes", 0, "expose the CPU time of the top this many processes")
+	serveCmd.Flags().StringVar(&connectSocket, "connect", "", "read from the mactop helperd listening on this socket instead of running powermetrics")
+	rootCmd.AddCommand(serveCmd)
+}
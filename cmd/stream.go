@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var streamCmd = &cobra.Command{
+	Use:   "stream",
+	Short: "Write one JSON object per sample to stdout",
+	Long: `stream skips the UI and writes every sample as a line of JSON, with the
+timestamp, SoC info, CPU, GPU, ANE, power, memory, network, disk and
+process metrics. It is the same as mactop --json.`,
+	RunE: func(c *cobra.Command, args []string) error {
+		headless = true
+		return run(c)
+	},
+}
+
+func init() {
+	streamCmd.Flags().StringVar(&connectSocket, "connect", "", "read from the mactop helperd listening on this socket instead of running powermetrics")
+	addStreamFlags(streamCmd)
+	addExportFlags(streamCmd)
+	rootCmd.AddCommand(streamCmd)
+}
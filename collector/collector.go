@@ -0,0 +1,302 @@
+package collector
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/linux"
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/sirupsen/logrus"
+)
+
+// Snapshot is the unit published to every consumer of the collector. A
+// snapshot is published for every powermetrics sample and carries the
+// latest value of every other source.
+type Snapshot struct {
+	Time time.Time
+	// Interval is the sample interval in milliseconds in effect when the
+	// snapshot was taken.
+	Interval int
+	CPU      parser.CPUMetrics
+	// CoreUsage is the usage of every logical core in percent. It is only
+	// sampled without root or on Linux, when CPU has no cluster residency.
+	CoreUsage []float64
+	// CoreFreqMHz is the frequency of every logical core, only sampled on
+	// Linux.
+	CoreFreqMHz []int
+	// Temperatures holds every hardware sensor in degrees Celsius, keyed
+	// by sensor name. Only sampled on Linux.
+	Temperatures map[string]float64
+	GPU          parser.GPUMetrics
+	// ThermalPressure is one of parser.ThermalPressureLevels, or empty
+	// when the thermal sampler is off.
+	ThermalPressure string
+	NetDisk         parser.NetDiskMetrics
+	Processes       []parser.ProcessMetrics
+	Memory          parser.MemoryMetrics
+	DiskSpace       parser.DiskSpaceMetrics
+	// Derived holds the values of the configured derived metrics that
+	// could be computed.
+	Derived map[string]float64
+	// Updated holds the time each source was last sampled.
+	Updated map[Source]time.Time
+	// Stale is set when any source has gone without new samples for
+	// longer than expected. StaleSources lists those sources.
+	Stale        bool
+	StaleSources []Source
+	Health       Health
+}
+
+// Age returns how old the values of source were when the snapshot was
+// taken. Sources that were never sampled have an age of zero.
+func (s *Snapshot) Age(source Source) time.Duration {
+	updated, ok := s.Updated[source]
+	if !ok {
+		return 0
+	}
+	return s.Time.Sub(updated)
+}
+
+// IsStale reports whether source is listed in StaleSources.
+func (s *Snapshot) IsStale(source Source) bool {
+	return slices.Contains(s.StaleSources, source)
+}
+
+// staleCheckInterval is how often the collector looks for sources that
+// stopped producing samples.
+const staleCheckInterval = time.Second
+
+// Controller changes the collector while it is running. It is implemented
+// by Collector and by anything relaying commands to one.
+type Controller interface {
+	Interval() int
+	SetInterval(interval int) error
+}
+
+// Collector runs the supervised powermetrics process next to the slower
+// memory, process and disk space sources, merges their results into
+// snapshots and publishes them, together with health changes, on buses any
+// number of consumers can attach to. Every snapshot is also recorded in the
+// shared history.
+type Collector struct {
+	opts       Options
+	supervisor *Supervisor
+	derived    []derivedMetric
+
+	Snapshots *bus.Bus[Snapshot]
+	Health    *bus.Bus[Health]
+	// History holds every published snapshot.
+	History *History
+}
+
+func New(modelName string, opts Options) *Collector {
+	defaults := DefaultOptions(opts.Interval)
+	if opts.MemoryInterval <= 0 {
+		opts.MemoryInterval = defaults.MemoryInterval
+	}
+	if opts.ProcessInterval <= 0 {
+		opts.ProcessInterval = defaults.ProcessInterval
+	}
+	if opts.DiskSpaceInterval <= 0 {
+		opts.DiskSpaceInterval = defaults.DiskSpaceInterval
+	}
+	if opts.DiskSpacePath == "" {
+		opts.DiskSpacePath = defaults.DiskSpacePath
+	}
+	if opts.History.RawSamples <= 0 {
+		opts.History = defaults.History
+	}
+	if opts.StaleIntervals <= 0 {
+		opts.StaleIntervals = defaults.StaleIntervals
+	}
+	derived, err := compileDerived(opts.Derived)
+	if err != nil {
+		logrus.Errorf("ignoring derived metrics: %v", err)
+	}
+	var derivedMetrics []Metric
+	for _, m := range derived {
+		derivedMetrics = append(derivedMetrics, m.Metric)
+	}
+	return &Collector{
+		opts:       opts,
+		supervisor: NewSupervisor(modelName, opts),
+		derived:    derived,
+		Snapshots:  bus.New[Snapshot](),
+		Health:     bus.New[Health](),
+		History:    NewHistory(opts.History, derivedMetrics...),
+	}
+}
+
+// Metrics returns the built-in metrics followed by the derived ones.
+func (c *Collector) Metrics() []Metric {
+	metrics := slices.Clone(Metrics)
+	for _, m := range c.derived {
+		metrics = append(metrics, m.Metric)
+	}
+	return metrics
+}
+
+func (c *Collector) Interval() int {
+	return c.supervisor.Interval()
+}
+
+// SetInterval changes the sample interval, restarting powermetrics.
+func (c *Collector) SetInterval(interval int) error {
+	return c.supervisor.SetInterval(interval)
+}
+
+// Run collects until done is closed and then closes both buses.
+func (c *Collector) Run(done <-chan struct{}) {
+	defer c.Snapshots.Close()
+	defer c.Health.Close()
+
+	samples := make(chan parser.Sample)
+	health := make(chan Health)
+	updates := make(chan update)
+	stopped := make(chan struct{})
+	// Snapshots are published whenever the primary source is updated.
+	primary := SourcePower
+	memory := sampleMemory
+	switch {
+	case c.opts.Backend == BackendLinux:
+		primary = SourceCPU
+		fs := linux.FS{Root: c.opts.SysRoot}
+		go func() {
+			defer close(stopped)
+			<-done
+		}()
+		go poll(done, updates, SourceCPU, c.interval, sampleLinuxCPU(fs))
+		go poll(done, updates, SourcePower, c.interval, sampleLinuxPower(fs))
+		go poll(done, updates, SourceNetDisk, c.interval, sampleLinuxNetDisk(fs))
+		if len(c.opts.ProcessStats) > 0 {
+			go poll(done, updates, SourceProcesses, every(c.opts.ProcessInterval), sampleProcesses())
+		}
+		memory = sampleLinuxMemory(fs)
+		c.Health.Publish(c.supervisor.Health())
+	case c.opts.Unprivileged:
+		primary = SourceCPU
+		go func() {
+			defer close(stopped)
+			<-done
+		}()
+		go poll(done, updates, SourceCPU, c.interval, sampleCores())
+		go poll(done, updates, SourceNetDisk, c.interval, sampleNetDisk())
+		if len(c.opts.ProcessStats) > 0 {
+			go poll(done, updates, SourceProcesses, every(c.opts.ProcessInterval), sampleProcesses())
+		}
+		c.Health.Publish(c.supervisor.Health())
+	default:
+		go func() {
+			defer close(stopped)
+			c.supervisor.Run(done, samples, health)
+		}()
+	}
+	go poll(done, updates, SourceMemory, every(c.opts.MemoryInterval), memory)
+	go poll(done, updates, SourceDiskSpace, every(c.opts.DiskSpaceInterval), sampleDiskSpace(c.opts.DiskSpacePath))
+
+	// The process table arrives with every powermetrics sample but is only
+	// refreshed at its own, slower rate.
+	processTicker := time.NewTicker(c.opts.ProcessInterval)
+	defer processTicker.Stop()
+	var pendingProcesses []parser.ProcessMetrics
+
+	// While powermetrics is silent, snapshots flagged as stale are still
+	// published so consumers stop showing the last values as current.
+	staleTicker := time.NewTicker(staleCheckInterval)
+	defer staleTicker.Stop()
+
+	current := Snapshot{Updated: make(map[Source]time.Time)}
+	for {
+		select {
+		case sample := <-samples:
+			now := time.Now()
+			current.CPU = sample.CPU
+			current.GPU = sample.GPU
+			current.NetDisk = sample.NetDisk
+			current.ThermalPressure = sample.ThermalPressure
+			c.setUpdated(&current, SourcePower, now)
+			c.setUpdated(&current, SourceNetDisk, now)
+			pendingProcesses = sample.Processes
+			if current.Updated[SourceProcesses].IsZero() && pendingProcesses != nil {
+				current.Processes = pendingProcesses
+				c.setUpdated(&current, SourceProcesses, now)
+				pendingProcesses = nil
+			}
+			c.publish(current, now)
+		case <-processTicker.C:
+			if pendingProcesses != nil {
+				current.Processes = pendingProcesses
+				c.setUpdated(&current, SourceProcesses, time.Now())
+				pendingProcesses = nil
+			}
+		case u := <-updates:
+			u.apply(&current)
+			c.setUpdated(&current, u.source, u.time)
+			if u.source == primary {
+				c.publish(current, u.time)
+			}
+		case now := <-staleTicker.C:
+			if c.isStale(&current, primary, now) {
+				c.publish(current, now)
+			}
+		case h := <-health:
+			c.Health.Publish(h)
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// interval returns the sample interval of powermetrics, or of the sources
+// replacing it.
+func (c *Collector) interval() time.Duration {
+	return time.Duration(c.supervisor.Interval()) * time.Millisecond
+}
+
+// expected returns the interval new samples of source should arrive at.
+func (c *Collector) expected(source Source) time.Duration {
+	power := c.interval()
+	switch source {
+	case SourceMemory:
+		return c.opts.MemoryInterval
+	case SourceProcesses:
+		return max(c.opts.ProcessInterval, power)
+	case SourceDiskSpace:
+		return c.opts.DiskSpaceInterval
+	}
+	return power
+}
+
+func (c *Collector) isStale(s *Snapshot, source Source, now time.Time) bool {
+	updated, ok := s.Updated[source]
+	return ok && now.Sub(updated) > time.Duration(c.opts.StaleIntervals)*c.expected(source)
+}
+
+// setUpdated records a new sample of source, marking a gap in the history
+// if the previous one is too old to be joined to it.
+func (c *Collector) setUpdated(s *Snapshot, source Source, t time.Time) {
+	if c.isStale(s, source, t) {
+		c.History.AddGap(source, s.Updated[source].Add(c.expected(source)))
+	}
+	s.Updated[source] = t
+}
+
+func (c *Collector) publish(current Snapshot, now time.Time) {
+	snapshot := current
+	snapshot.Time = now
+	snapshot.Interval = c.supervisor.Interval()
+	snapshot.Updated = maps.Clone(current.Updated)
+	snapshot.StaleSources = nil
+	for _, source := range Sources {
+		if c.isStale(&current, source, now) {
+			snapshot.StaleSources = append(snapshot.StaleSources, source)
+		}
+	}
+	snapshot.Stale = len(snapshot.StaleSources) > 0
+	snapshot.Health = c.supervisor.Health()
+	snapshot.Derived = evalDerived(c.derived, &snapshot)
+	c.History.Record(&snapshot)
+	c.Snapshots.Publish(snapshot)
+}
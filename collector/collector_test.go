@@ -0,0 +1,171 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/testharness"
+)
+
+func TestSetIntervalRestartsPowermetrics(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	opts := DefaultOptions(1000)
+	opts.Runner = bin.Runner()
+	c := New("Apple M1 Pro", opts)
+	snapshots := c.Snapshots.Subscribe("test", 16, bus.DropOldest)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.Run(done)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	first := <-snapshots.C
+	if first.Interval != 1000 {
+		t.Fatalf("got interval %d, want 1000", first.Interval)
+	}
+	if err := c.SetInterval(50); err == nil {
+		t.Fatal("expected an error for an interval below MinInterval")
+	}
+	if err := c.SetInterval(250); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case snapshot := <-snapshots.C:
+			if snapshot.Interval != 250 || len(bin.Calls("powermetrics")) < 2 {
+				continue
+			}
+			if snapshot.Health.Restarts != 0 || snapshot.Health.State != StateRunning {
+				t.Errorf("interval change was reported as a failure: %+v", snapshot.Health)
+			}
+			calls := bin.Calls("powermetrics")
+			if len(calls) != 2 || !strings.HasSuffix(calls[1], "-i 250") {
+				t.Errorf("unexpected powermetrics invocations %q", calls)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no snapshot with the new interval")
+		}
+	}
+}
+
+func TestSnapshotsMergeSlowerSources(t *testing.T) {
+	bin := testharness.MacBookPro(t, 20*time.Millisecond)
+	opts := DefaultOptions(100)
+	opts.Runner = bin.Runner()
+	opts.MemoryInterval = 10 * time.Millisecond
+	opts.ProcessInterval = time.Hour
+	c := New("Apple M1 Pro", opts)
+	snapshots := c.Snapshots.Subscribe("test", 16, bus.DropOldest)
+
+	done := make(chan struct{})
+	go c.Run(done)
+	defer close(done)
+
+	var first, later Snapshot
+	first = <-snapshots.C
+	for later = range snapshots.C {
+		if !later.Updated[SourceMemory].IsZero() && later.Updated[SourceMemory].After(first.Updated[SourceMemory]) {
+			break
+		}
+	}
+
+	if later.Memory.Total == 0 {
+		t.Error("expected memory to be sampled")
+	}
+	if len(later.Processes) != 3 || !later.Updated[SourceProcesses].Equal(first.Updated[SourceProcesses]) {
+		t.Errorf("expected the process table to keep its first sample, got updated %v and %v",
+			first.Updated[SourceProcesses], later.Updated[SourceProcesses])
+	}
+	if !later.Updated[SourcePower].After(first.Updated[SourcePower]) {
+		t.Error("expected a newer power sample")
+	}
+}
+
+func TestSnapshotsMarkSilentPowermetricsStale(t *testing.T) {
+	bin := testharness.NewBin(t)
+	bin.Replay("powermetrics", testharness.Replay{Fixture: "powermetrics_m1pro.txt", Count: 1, Interval: 5 * time.Second})
+	opts := DefaultOptions(100)
+	opts.Runner = bin.Runner()
+	c := New("Apple M1 Pro", opts)
+	snapshots := c.Snapshots.Subscribe("test", 16, bus.DropOldest)
+
+	done := make(chan struct{})
+	go c.Run(done)
+	defer close(done)
+
+	first := <-snapshots.C
+	if first.Stale {
+		t.Fatalf("first snapshot is stale: %v", first.StaleSources)
+	}
+	select {
+	case stale := <-snapshots.C:
+		if !stale.Stale || !stale.IsStale(SourcePower) {
+			t.Fatalf("expected power to be stale, got %v", stale.StaleSources)
+		}
+		if age := stale.Age(SourcePower); age < 300*time.Millisecond {
+			t.Errorf("got age %v, want at least 300ms", age)
+		}
+		if stale.CPU.PackageW != first.CPU.PackageW || stale.GPU != first.GPU {
+			t.Error("expected the stale snapshot to keep the last values")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no stale snapshot")
+	}
+}
+
+func TestSupervisorKeepsSplitSamplesWhole(t *testing.T) {
+	bin := testharness.NewBin(t)
+	fixture := testharness.Fixture("powermetrics_m1pro.txt")
+	// The second sample arrives in two chunks, split in its process table
+	// with a gap just short of idleFlush.
+	bin.Script("powermetrics", fmt.Sprintf("cat %[1]q\nsed -n '8,15p' %[1]q\nsleep %.3f\nsed -n '16,$p' %[1]q\nexec sleep 10",
+		fixture, (idleFlush*8/10).Seconds()))
+	opts := DefaultOptions(1000)
+	opts.Runner = bin.Runner()
+	s := NewSupervisor("Apple M1 Pro", opts)
+
+	samples := make(chan parser.Sample)
+	health := make(chan Health)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.Run(done, samples, health)
+	}()
+	go func() {
+		for range health {
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+		close(health)
+	}()
+
+	// Taking the first sample late lets the idle timer fire while it is
+	// being sent.
+	time.Sleep(3 * idleFlush)
+	for i := 0; i < 2; i++ {
+		select {
+		case sample := <-samples:
+			if len(sample.Processes) != 3 {
+				t.Errorf("sample %d has %d processes, want 3", i, len(sample.Processes))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d samples, want 2", i)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/expr"
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+// Derived defines a metric computed from other metrics with an expression
+// such as "GPUW / PackageW". Expressions may refer to every built-in metric
+// and to derived metrics defined before them, and may call min, max, abs
+// and the process functions below.
+type Derived struct {
+	Name string
+	Expr string
+	Unit string
+	Help string
+}
+
+// processFuncs are the functions over the process table available to
+// derived metrics. Each takes process names and sums the matching
+// processes, or every process when called without arguments.
+var processFuncs = map[string]func(p parser.ProcessMetrics) float64{
+	"process_cpu": func(p parser.ProcessMetrics) float64 { return p.CPUUsage },
+	"process_count": func(parser.ProcessMetrics) float64 {
+		return 1
+	},
+}
+
+var derivedName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// derivedMetric is a compiled Derived.
+type derivedMetric struct {
+	Metric
+	expr *expr.Expr
+}
+
+// compileDerived parses and checks every definition. The metric of a
+// derived value is recorded with the fastest source it depends on.
+func compileDerived(defs []Derived) ([]derivedMetric, error) {
+	sources := make(map[string]Source, len(Metrics)+len(defs))
+	for _, m := range Metrics {
+		sources[m.Name] = m.Source
+	}
+
+	var compiled []derivedMetric
+	for _, def := range defs {
+		if !derivedName.MatchString(def.Name) {
+			return nil, fmt.Errorf("invalid derived metric name %q", def.Name)
+		}
+		if _, ok := sources[def.Name]; ok {
+			return nil, fmt.Errorf("derived metric %s is already defined", def.Name)
+		}
+		e, err := expr.Parse(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("derived metric %s: %w", def.Name, err)
+		}
+
+		var deps []Source
+		for _, name := range e.Vars() {
+			source, ok := sources[name]
+			if !ok {
+				return nil, fmt.Errorf("derived metric %s: unknown metric %s", def.Name, name)
+			}
+			deps = append(deps, source)
+		}
+		for _, name := range e.Funcs() {
+			if _, ok := processFuncs[name]; ok {
+				deps = append(deps, SourceProcesses)
+			} else if _, ok := expr.Builtins[name]; !ok {
+				return nil, fmt.Errorf("derived metric %s: unknown function %s", def.Name, name)
+			}
+		}
+		// A derived value is computed when one of its sources is sampled,
+		// so one without sources would never be.
+		if len(deps) == 0 {
+			return nil, fmt.Errorf("derived metric %s: %q uses no metric or process function", def.Name, def.Expr)
+		}
+		var source Source
+		for _, s := range []Source{SourceDiskSpace, SourceMemory, SourceProcesses, SourceCPU, SourceNetDisk, SourcePower} {
+			if slices.Contains(deps, s) {
+				source = s
+			}
+		}
+
+		name := def.Name
+		help := def.Help
+		if help == "" {
+			help = def.Expr
+		}
+		compiled = append(compiled, derivedMetric{
+			Metric: Metric{
+				Name:   name,
+				Unit:   def.Unit,
+				Help:   help,
+				Source: source,
+				Value: func(s *Snapshot) float64 {
+					if v, ok := s.Derived[name]; ok {
+						return v
+					}
+					return math.NaN()
+				},
+			},
+			expr: e,
+		})
+		sources[name] = source
+	}
+	return compiled, nil
+}
+
+// DerivedMetrics returns the metrics defined by defs, as they would be
+// computed by a collector.
+func DerivedMetrics(defs []Derived) ([]Metric, error) {
+	derived, err := compileDerived(defs)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]Metric, len(derived))
+	for i, m := range derived {
+		metrics[i] = m.Metric
+	}
+	return metrics, nil
+}
+
+// evalDerived computes every derived metric of a snapshot. Metrics that
+// cannot be computed, for instance because of a division by zero, are left
+// out.
+func evalDerived(derived []derivedMetric, s *Snapshot) map[string]float64 {
+	if len(derived) == 0 {
+		return nil
+	}
+	env := expr.Env{
+		Vars:  s.Values(),
+		Funcs: make(map[string]expr.Func, len(processFuncs)),
+	}
+	for name, value := range processFuncs {
+		env.Funcs[name] = func(args []expr.Value) (float64, error) {
+			for _, arg := range args {
+				if !arg.IsString {
+					return 0, errors.New("expected process names")
+				}
+			}
+			var sum float64
+			for _, p := range s.Processes {
+				if matchProcess(p, args) {
+					sum += value(p)
+				}
+			}
+			return sum, nil
+		}
+	}
+
+	values := make(map[string]float64, len(derived))
+	for _, m := range derived {
+		v, err := m.expr.Eval(env)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		values[m.Name] = v
+		env.Vars[m.Name] = v
+	}
+	return values
+}
+
+func matchProcess(p parser.ProcessMetrics, names []expr.Value) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if strings.EqualFold(p.Name, name.String) {
+			return true
+		}
+	}
+	return false
+}
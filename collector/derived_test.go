@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+func TestDerivedMetrics(t *testing.T) {
+	derived, err := compileDerived([]Derived{
+		{Name: "gpu_share", Expr: "GPUW / PackageW"},
+		{Name: "gpu_percent", Expr: "gpu_share * 100", Unit: "%"},
+		{Name: "browser_cpu", Expr: `process_cpu("Safari", "safari networking")`},
+		{Name: "swap_share", Expr: "SwapUsed / SwapTotal"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived[0].Source != SourcePower || derived[2].Source != SourceProcesses || derived[3].Source != SourceMemory {
+		t.Errorf("unexpected sources %s, %s, %s", derived[0].Source, derived[2].Source, derived[3].Source)
+	}
+
+	s := Snapshot{
+		CPU: parser.CPUMetrics{GPUW: 1, PackageW: 4},
+		Processes: []parser.ProcessMetrics{
+			{ID: 1, Name: "Safari", CPUUsage: 10},
+			{ID: 2, Name: "Safari Networking", CPUUsage: 2.5},
+			{ID: 3, Name: "WindowServer", CPUUsage: 40},
+		},
+	}
+	s.Derived = evalDerived(derived, &s)
+	want := map[string]float64{"gpu_share": 0.25, "gpu_percent": 25, "browser_cpu": 12.5}
+	for name, v := range want {
+		if math.Abs(s.Derived[name]-v) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, s.Derived[name], v)
+		}
+	}
+	if _, ok := s.Derived["swap_share"]; ok {
+		t.Error("expected a division by zero to leave the metric out")
+	}
+	if values := s.Values(); values["gpu_percent"] != 25 || values["GPUW"] != 1 {
+		t.Error("expected Values to include built-in and derived metrics")
+	}
+
+	h := NewHistory(DefaultHistoryOptions(), derived[0].Metric, derived[3].Metric)
+	s.Updated = map[Source]time.Time{SourcePower: time.Now(), SourceMemory: time.Now()}
+	h.Record(&s)
+	if _, ok := h.Latest("gpu_share"); !ok {
+		t.Error("expected the derived metric to be recorded")
+	}
+	if _, ok := h.Latest("swap_share"); ok {
+		t.Error("expected a missing derived value not to be recorded")
+	}
+}
+
+func TestCompileDerivedErrors(t *testing.T) {
+	for _, d := range []Derived{
+		{Name: "bad name", Expr: "1"},
+		{Name: "CPUW", Expr: "1"},
+		{Name: "x", Expr: "GPUW /"},
+		{Name: "x", Expr: "Unknown * 2"},
+		{Name: "x", Expr: "sqrt(CPUW)"},
+		{Name: "x", Expr: "2 * 3"},
+		{Name: "x", Expr: "max(1, 2)"},
+	} {
+		if _, err := compileDerived([]Derived{d}); err == nil {
+			t.Errorf("expected an error for %+v", d)
+		}
+	}
+	if _, err := compileDerived([]Derived{{Name: "a", Expr: "b"}, {Name: "b", Expr: "1"}}); err == nil {
+		t.Error("expected forward references to be rejected")
+	}
+}
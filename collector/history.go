@@ -0,0 +1,323 @@
+package collector
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Point is a history entry. Full-resolution points have Min, Avg and Max
+// set to the sampled value and a Count of one; downsampled points summarize
+// every sample that fell into the bucket starting at Time. A gap in the
+// samples is marked by a point with a Count of zero and NaN values.
+type Point struct {
+	Time  time.Time
+	Min   float64
+	Avg   float64
+	Max   float64
+	Count int
+}
+
+// Gap reports whether the point marks missing samples.
+func (p Point) Gap() bool {
+	return p.Count == 0
+}
+
+func (p *Point) merge(o Point) {
+	if p.Count == 0 {
+		*p = o
+		return
+	}
+	if o.Count == 0 {
+		return
+	}
+	p.Min = min(p.Min, o.Min)
+	p.Max = max(p.Max, o.Max)
+	p.Avg = (p.Avg*float64(p.Count) + o.Avg*float64(o.Count)) / float64(p.Count+o.Count)
+	p.Count += o.Count
+}
+
+// Tier is a downsampled level of the history.
+type Tier struct {
+	Resolution time.Duration
+	// Length is the number of buckets kept.
+	Length int
+}
+
+type HistoryOptions struct {
+	// RawSamples is the number of full-resolution samples kept per metric.
+	RawSamples int
+	Tiers      []Tier
+}
+
+// DefaultHistoryOptions keeps 1200 raw samples, an hour at 10 s resolution
+// and a day at 1 min resolution for every metric, which bounds the store to
+// roughly 170 KB per metric.
+func DefaultHistoryOptions() HistoryOptions {
+	return HistoryOptions{
+		RawSamples: 1200,
+		Tiers: []Tier{
+			{Resolution: 10 * time.Second, Length: 360},
+			{Resolution: time.Minute, Length: 1440},
+		},
+	}
+}
+
+// History is a bounded in-memory store of metric values shared by graphs,
+// statistics and exporters. Every metric keeps a ring of full-resolution
+// samples plus min/avg/max tiers that cover progressively longer windows.
+type History struct {
+	opts HistoryOptions
+	// metrics are the metrics Record and AddGap cover.
+	metrics []Metric
+
+	mu       sync.RWMutex
+	series   map[string]*series
+	recorded map[Source]time.Time
+}
+
+type series struct {
+	raw   *ring[Point]
+	tiers []*tierSeries
+}
+
+type tierSeries struct {
+	resolution time.Duration
+	points     *ring[Point]
+	current    Point
+}
+
+// NewHistory returns a history of the built-in metrics and the given
+// derived ones.
+func NewHistory(opts HistoryOptions, derived ...Metric) *History {
+	return &History{
+		opts:     opts,
+		metrics:  append(slices.Clone(Metrics), derived...),
+		series:   make(map[string]*series),
+		recorded: make(map[Source]time.Time),
+	}
+}
+
+// Record adds the metrics of every source that was updated since the last
+// recorded snapshot, timestamped with the time the source was sampled.
+func (h *History) Record(s *Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for source, updated := range s.Updated {
+		if !updated.After(h.recorded[source]) {
+			continue
+		}
+		h.recorded[source] = updated
+		for _, m := range h.metrics {
+			if m.Source != source {
+				continue
+			}
+			if v := m.Value(s); !math.IsNaN(v) {
+				h.add(m.Name, updated, v)
+			}
+		}
+	}
+}
+
+// Add records a single value.
+func (h *History) Add(name string, t time.Time, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(name, t, value)
+}
+
+func (h *History) add(name string, t time.Time, value float64) {
+	s, ok := h.series[name]
+	if !ok {
+		s = &series{raw: newRing[Point](h.opts.RawSamples)}
+		for _, tier := range h.opts.Tiers {
+			s.tiers = append(s.tiers, &tierSeries{
+				resolution: tier.Resolution,
+				points:     newRing[Point](tier.Length),
+			})
+		}
+		h.series[name] = s
+	}
+
+	point := Point{Time: t, Min: value, Avg: value, Max: value, Count: 1}
+	s.raw.push(point)
+	for _, tier := range s.tiers {
+		bucket := t.Truncate(tier.resolution)
+		if tier.current.Count > 0 && !bucket.Equal(tier.current.Time) {
+			tier.points.push(tier.current)
+			tier.current = Point{}
+		}
+		point.Time = bucket
+		tier.current.merge(point)
+	}
+}
+
+// AddGap marks that the metrics of source were not sampled after t. The
+// full-resolution ring gets an explicit gap point, and the tiers close their
+// current bucket and get one too, so later values are never averaged with
+// older ones, not even when downsampled.
+func (h *History) AddGap(source Source, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	gap := Point{Time: t, Min: math.NaN(), Avg: math.NaN(), Max: math.NaN()}
+	for _, m := range h.metrics {
+		if m.Source != source {
+			continue
+		}
+		s, ok := h.series[m.Name]
+		if !ok {
+			continue
+		}
+		s.raw.push(gap)
+		for _, tier := range s.tiers {
+			if tier.current.Count > 0 {
+				tier.points.push(tier.current)
+				tier.current = Point{}
+			}
+			tierGap := gap
+			tierGap.Time = t.Truncate(tier.resolution)
+			tier.points.push(tierGap)
+		}
+	}
+}
+
+// Names returns the recorded metric names in sorted order.
+func (h *History) Names() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.series))
+	for name := range h.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Latest returns the most recent full-resolution point of a metric.
+func (h *History) Latest(name string) (Point, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, ok := h.series[name]
+	if !ok || s.raw.len() == 0 {
+		return Point{}, false
+	}
+	return s.raw.at(s.raw.len() - 1), true
+}
+
+// Query returns the points of a metric at or after since, oldest first. It
+// reads from the finest level that still reaches back to since, and
+// downsamples further when resolution is coarser than that level. A zero
+// resolution returns the points of the chosen level as they are.
+func (h *History) Query(name string, since time.Time, resolution time.Duration) []Point {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, ok := h.series[name]
+	if !ok {
+		return nil
+	}
+
+	// Levels from finest to coarsest; the raw ring has no resolution.
+	type level struct {
+		resolution time.Duration
+		points     []Point
+	}
+	levels := []level{{0, s.raw.slice()}}
+	for _, tier := range s.tiers {
+		points := tier.points.slice()
+		if tier.current.Count > 0 {
+			points = append(points, tier.current)
+		}
+		levels = append(levels, level{tier.resolution, points})
+	}
+
+	chosen := -1
+	for i, l := range levels {
+		if len(l.points) == 0 {
+			continue
+		}
+		if !l.points[0].Time.After(since) {
+			chosen = i
+			break
+		}
+		if chosen < 0 || l.points[0].Time.Before(levels[chosen].points[0].Time) {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		return nil
+	}
+
+	points := levels[chosen].points
+	start := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(since.Truncate(max(levels[chosen].resolution, 1)))
+	})
+	points = points[start:]
+	if resolution <= levels[chosen].resolution {
+		return points
+	}
+	return downsample(points, resolution)
+}
+
+// downsample merges points into buckets of resolution. A gap point ends
+// the bucket and is kept, so points on either side are never merged.
+func downsample(points []Point, resolution time.Duration) []Point {
+	var out []Point
+	for _, p := range points {
+		p.Time = p.Time.Truncate(resolution)
+		if len(out) > 0 {
+			last := &out[len(out)-1]
+			if p.Gap() && last.Gap() {
+				continue
+			}
+			if !p.Gap() && !last.Gap() && last.Time.Equal(p.Time) {
+				last.merge(p)
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// ring is a fixed-capacity buffer that overwrites its oldest entry when
+// full.
+type ring[T any] struct {
+	items []T
+	start int
+	size  int
+}
+
+func newRing[T any](capacity int) *ring[T] {
+	return &ring[T]{items: make([]T, max(capacity, 1))}
+}
+
+func (r *ring[T]) push(v T) {
+	if r.size < len(r.items) {
+		r.items[(r.start+r.size)%len(r.items)] = v
+		r.size++
+		return
+	}
+	r.items[r.start] = v
+	r.start = (r.start + 1) % len(r.items)
+}
+
+func (r *ring[T]) len() int {
+	return r.size
+}
+
+// at returns the i-th entry, oldest first.
+func (r *ring[T]) at(i int) T {
+	return r.items[(r.start+i)%len(r.items)]
+}
+
+func (r *ring[T]) slice() []T {
+	out := make([]T, r.size)
+	for i := range out {
+		out[i] = r.at(i)
+	}
+	return out
+}
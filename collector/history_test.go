@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistoryTiers(t *testing.T) {
+	h := NewHistory(HistoryOptions{
+		RawSamples: 10,
+		Tiers: []Tier{
+			{Resolution: 10 * time.Second, Length: 6},
+			{Resolution: time.Minute, Length: 10},
+		},
+	})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// One sample per second for five minutes, cycling through 0..9.
+	for i := 0; i < 300; i++ {
+		h.Add("CPUW", start.Add(time.Duration(i)*time.Second), float64(i%10))
+	}
+
+	end := start.Add(299 * time.Second)
+	if raw := h.Query("CPUW", end.Add(-9*time.Second), 0); len(raw) != 10 || raw[0].Count != 1 {
+		t.Fatalf("expected the last 10 raw samples, got %d", len(raw))
+	}
+	if latest, ok := h.Latest("CPUW"); !ok || !latest.Time.Equal(end) || latest.Avg != 9 {
+		t.Errorf("unexpected latest point %+v", latest)
+	}
+
+	// A minute back is out of reach of the raw ring, so the 10 s tier is
+	// used.
+	tenSeconds := h.Query("CPUW", end.Add(-time.Minute), 0)
+	if len(tenSeconds) != 7 {
+		t.Fatalf("got %d 10 s points, want 7", len(tenSeconds))
+	}
+	for _, p := range tenSeconds {
+		if p.Min != 0 || p.Max != 9 || math.Abs(p.Avg-4.5) > 1e-9 || p.Count != 10 {
+			t.Errorf("unexpected 10 s point %+v", p)
+		}
+	}
+
+	// Five minutes back only the 1 min tier reaches.
+	minutes := h.Query("CPUW", start, 0)
+	if len(minutes) != 5 || minutes[0].Count != 60 || math.Abs(minutes[0].Avg-4.5) > 1e-9 {
+		t.Fatalf("unexpected 1 min points %+v", minutes)
+	}
+
+	// Downsampling the 10 s tier to 30 s buckets.
+	thirty := h.Query("CPUW", end.Add(-time.Minute), 30*time.Second)
+	if len(thirty) != 3 || thirty[0].Count != 10 || thirty[1].Count != 30 || thirty[2].Max != 9 {
+		t.Fatalf("unexpected 30 s points %+v", thirty)
+	}
+
+	if h.Query("missing", start, 0) != nil {
+		t.Error("expected no points for an unknown metric")
+	}
+}
+
+func TestHistoryRecordOnlyUpdatedSources(t *testing.T) {
+	h := NewHistory(DefaultHistoryOptions())
+	now := time.Now()
+	snapshot := Snapshot{Updated: map[Source]time.Time{SourcePower: now, SourceMemory: now}}
+	snapshot.CPU.CPUW = 1
+
+	h.Record(&snapshot)
+	snapshot.Updated = map[Source]time.Time{SourcePower: now.Add(time.Second), SourceMemory: now}
+	snapshot.CPU.CPUW = 2
+	h.Record(&snapshot)
+
+	if n := len(h.Query("CPUW", now, 0)); n != 2 {
+		t.Errorf("got %d power points, want 2", n)
+	}
+	if n := len(h.Query("MemoryUsed", now, 0)); n != 1 {
+		t.Errorf("got %d memory points, want 1", n)
+	}
+	if n := len(h.Query("DiskUsed", now, 0)); n != 0 {
+		t.Errorf("got %d disk points, want 0", n)
+	}
+}
+
+func TestHistoryGaps(t *testing.T) {
+	h := NewHistory(HistoryOptions{
+		RawSamples: 3,
+		Tiers:      []Tier{{Resolution: 10 * time.Second, Length: 6}},
+	})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.Add("CPUW", start, 1)
+	h.Add("CPUW", start.Add(time.Second), 1)
+	h.AddGap(SourcePower, start.Add(2*time.Second))
+	h.Add("CPUW", start.Add(5*time.Second), 5)
+
+	raw := h.Query("CPUW", start.Add(time.Second), 0)
+	if len(raw) != 3 || !raw[1].Gap() || !math.IsNaN(raw[1].Avg) {
+		t.Fatalf("expected a gap point between the samples, got %+v", raw)
+	}
+	if latest, _ := h.Latest("CPUW"); latest.Avg != 5 {
+		t.Errorf("unexpected latest point %+v", latest)
+	}
+
+	// Samples on both sides of a gap are not averaged into one bucket.
+	tier := h.Query("CPUW", start, 0)
+	if len(tier) != 3 || tier[0].Avg != 1 || !tier[1].Gap() || tier[2].Avg != 5 {
+		t.Fatalf("unexpected 10 s points %+v", tier)
+	}
+	if merged := h.Query("CPUW", start, time.Minute); len(merged) != 3 || merged[0].Count != 2 || !merged[1].Gap() || merged[2].Avg != 5 {
+		t.Fatalf("unexpected points downsampled from the tier %+v", merged)
+	}
+
+	// Nor are they when downsampling the full-resolution points.
+	if merged := h.Query("CPUW", start.Add(time.Second), 10*time.Second); len(merged) != 3 || merged[0].Avg != 1 || !merged[1].Gap() || merged[2].Avg != 5 {
+		t.Fatalf("unexpected downsampled points %+v", merged)
+	}
+}
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/context-labs/mactop/v2/linux"
+)
+
+// The samplers below make up the linux backend. Like the unprivileged
+// samplers, the ones reporting rates only take a baseline on their first
+// call.
+
+// sampleLinuxCPU reports the usage and frequency of every core and the
+// hardware temperatures.
+func sampleLinuxCPU(fs linux.FS) sampleFunc {
+	var last []linux.CPUTimes
+	return func() (func(*Snapshot), error) {
+		times, err := fs.ReadCPUTimes()
+		if err != nil {
+			return nil, err
+		}
+		prev := last
+		last = times
+		usage := linux.CoreUsage(prev, times)
+		if usage == nil || prev == nil {
+			return nil, errBaseline
+		}
+		// Frequencies and temperatures are missing in containers and
+		// virtual machines, which is no reason to drop the usage.
+		freqs, _ := fs.ReadCoreFreqs()
+		temps, _ := fs.ReadTemperatures()
+		return func(s *Snapshot) {
+			s.CoreUsage = usage
+			s.CoreFreqMHz = freqs
+			s.Temperatures = temps
+		}, nil
+	}
+}
+
+// sampleLinuxPower reports the RAPL power rails and the GPU. Reading RAPL
+// counters usually needs root; without them only the GPU is reported.
+func sampleLinuxPower(fs linux.FS) sampleFunc {
+	var last []linux.RAPLZone
+	var lastTime time.Time
+	return func() (func(*Snapshot), error) {
+		now := time.Now()
+		zones, _ := fs.ReadRAPL()
+		gpu, hasGPU, _ := fs.ReadGPU()
+		prev, prevTime := last, lastTime
+		last, lastTime = zones, now
+		if len(zones) == 0 && !hasGPU {
+			return nil, errBaseline
+		}
+		if len(zones) > 0 && prev == nil {
+			return nil, errBaseline
+		}
+		power := linux.Power(prev, zones, now.Sub(prevTime).Seconds())
+		return func(s *Snapshot) {
+			s.CPU = power
+			s.GPU = gpu
+		}, nil
+	}
+}
+
+// sampleLinuxNetDisk reports network and disk activity from procfs.
+func sampleLinuxNetDisk(fs linux.FS) sampleFunc {
+	var last *linux.NetDiskCounters
+	var lastTime time.Time
+	return func() (func(*Snapshot), error) {
+		now := time.Now()
+		counters, err := fs.ReadNetDisk()
+		if err != nil {
+			return nil, err
+		}
+		prev, prevTime := last, lastTime
+		last, lastTime = &counters, now
+		if prev == nil {
+			return nil, errBaseline
+		}
+		metrics := linux.Rates(*prev, counters, now.Sub(prevTime).Seconds())
+		return func(s *Snapshot) {
+			s.NetDisk = metrics
+		}, nil
+	}
+}
+
+func sampleLinuxMemory(fs linux.FS) sampleFunc {
+	return func() (func(*Snapshot), error) {
+		memory, err := fs.ReadMemory()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Snapshot) {
+			s.Memory = memory
+		}, nil
+	}
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/bus"
+)
+
+func TestLinuxBackend(t *testing.T) {
+	opts := DefaultOptions(MinInterval)
+	opts.Backend = BackendLinux
+	opts.SysRoot = "../linux/testdata/root"
+	c := New("", opts)
+	snapshots := c.Snapshots.Subscribe("test", 8, bus.DropOldest)
+	done := make(chan struct{})
+	go c.Run(done)
+	defer close(done)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-snapshots.C:
+			// The first usage reading is a baseline, so every source has
+			// been sampled by the time a snapshot has the memory too.
+			if s.Updated[SourceMemory].IsZero() || s.Updated[SourcePower].IsZero() {
+				continue
+			}
+			if len(s.CoreUsage) != 2 || len(s.CoreFreqMHz) != 2 || s.CoreFreqMHz[0] != 2400 {
+				t.Errorf("unexpected cores %v %v", s.CoreUsage, s.CoreFreqMHz)
+			}
+			if s.Temperatures["coretemp Package id 0"] != 55 {
+				t.Errorf("unexpected temperatures %v", s.Temperatures)
+			}
+			if s.GPU.Active != 37 || s.Memory.Total != 16384000*1024 {
+				t.Errorf("unexpected GPU %+v or memory %+v", s.GPU, s.Memory)
+			}
+			if s.Health.State != StateUnavailable {
+				t.Errorf("expected powermetrics to be unavailable, got %+v", s.Health)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no complete snapshot")
+		}
+	}
+}
+
+func TestNeedsRoot(t *testing.T) {
+	opts := DefaultOptions(1000)
+	if !opts.NeedsRoot() {
+		t.Error("expected powermetrics to need root")
+	}
+	opts.Backend = BackendLinux
+	opts.Samplers = []string{"network", "disk"}
+	if !opts.NeedsRoot() {
+		t.Error("expected RAPL to need root whatever the samplers")
+	}
+	opts = DefaultOptions(1000)
+	opts.Unprivileged = true
+	if opts.NeedsRoot() {
+		t.Error("expected the unprivileged mode not to need root")
+	}
+}
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"slices"
+
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+// Metric is a numeric value that can be read from a snapshot. Metric names
+// are shared by the history store and every exporter.
+type Metric struct {
+	Name   string
+	Unit   string
+	Help   string
+	Source Source
+	Value  func(s *Snapshot) float64
+}
+
+// Metrics lists every built-in metric.
+var Metrics = []Metric{
+	{"EClusterActive", "%", "E-cluster active residency", SourcePower, func(s *Snapshot) float64 { return float64(s.CPU.EClusterActive) }},
+	{"EClusterFreqMHz", "MHz", "E-cluster frequency", SourcePower, func(s *Snapshot) float64 { return float64(s.CPU.EClusterFreqMHz) }},
+	{"PClusterActive", "%", "P-cluster active residency", SourcePower, func(s *Snapshot) float64 { return float64(s.CPU.PClusterActive) }},
+	{"PClusterFreqMHz", "MHz", "P-cluster frequency", SourcePower, func(s *Snapshot) float64 { return float64(s.CPU.PClusterFreqMHz) }},
+	{"CPUW", "W", "CPU power", SourcePower, func(s *Snapshot) float64 { return s.CPU.CPUW }},
+	{"GPUW", "W", "GPU power", SourcePower, func(s *Snapshot) float64 { return s.CPU.GPUW }},
+	{"ANEW", "W", "ANE power", SourcePower, func(s *Snapshot) float64 { return s.CPU.ANEW }},
+	{"PackageW", "W", "Combined CPU, GPU and ANE power", SourcePower, func(s *Snapshot) float64 { return s.CPU.PackageW }},
+	{"DRAMW", "W", "DRAM power, only measured on Linux", SourcePower, func(s *Snapshot) float64 { return s.CPU.DRAMW }},
+	{"GPUActive", "%", "GPU active residency", SourcePower, func(s *Snapshot) float64 { return s.GPU.Active }},
+	{"GPUFreqMHz", "MHz", "GPU frequency", SourcePower, func(s *Snapshot) float64 { return float64(s.GPU.FreqMHz) }},
+	{"OutPacketsPerSec", "packets/s", "Network packets sent", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.OutPacketsPerSec }},
+	{"OutBytesPerSec", "bytes/s", "Network bytes sent", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.OutBytesPerSec }},
+	{"InPacketsPerSec", "packets/s", "Network packets received", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.InPacketsPerSec }},
+	{"InBytesPerSec", "bytes/s", "Network bytes received", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.InBytesPerSec }},
+	{"ReadOpsPerSec", "ops/s", "Disk read operations", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.ReadOpsPerSec }},
+	{"ReadKBytesPerSec", "KB/s", "Disk bytes read", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.ReadKBytesPerSec }},
+	{"WriteOpsPerSec", "ops/s", "Disk write operations", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.WriteOpsPerSec }},
+	{"WriteKBytesPerSec", "KB/s", "Disk bytes written", SourceNetDisk, func(s *Snapshot) float64 { return s.NetDisk.WriteKBytesPerSec }},
+	{"CPUUsage", "%", "Average usage of all cores", SourceCPU, func(s *Snapshot) float64 { return mean(s.CoreUsage) }},
+	{"CPUFreqMHz", "MHz", "Average frequency of all cores", SourceCPU, func(s *Snapshot) float64 { return meanInt(s.CoreFreqMHz) }},
+	{"MaxTemperature", "°C", "Hottest hardware sensor", SourceCPU, func(s *Snapshot) float64 { return maxValue(s.Temperatures) }},
+	{"MemoryTotal", "bytes", "Physical memory", SourceMemory, func(s *Snapshot) float64 { return float64(s.Memory.Total) }},
+	{"MemoryUsed", "bytes", "Used memory", SourceMemory, func(s *Snapshot) float64 { return float64(s.Memory.Used) }},
+	{"MemoryAvailable", "bytes", "Available memory", SourceMemory, func(s *Snapshot) float64 { return float64(s.Memory.Available) }},
+	{"SwapTotal", "bytes", "Swap size", SourceMemory, func(s *Snapshot) float64 { return float64(s.Memory.SwapTotal) }},
+	{"SwapUsed", "bytes", "Used swap", SourceMemory, func(s *Snapshot) float64 { return float64(s.Memory.SwapUsed) }},
+	{"DiskTotal", "bytes", "Disk size", SourceDiskSpace, func(s *Snapshot) float64 { return float64(s.DiskSpace.Total) }},
+	{"DiskUsed", "bytes", "Used disk space", SourceDiskSpace, func(s *Snapshot) float64 { return float64(s.DiskSpace.Used) }},
+	{"DiskFree", "bytes", "Free disk space", SourceDiskSpace, func(s *Snapshot) float64 { return float64(s.DiskSpace.Free) }},
+	{"ThermalPressure", "level", "Thermal pressure from 0 (nominal) to 4 (sleeping)", SourcePower, func(s *Snapshot) float64 {
+		return float64(max(slices.Index(parser.ThermalPressureLevels, s.ThermalPressure), 0))
+	}},
+}
+
+// Values returns every metric of the snapshot by name, derived metrics
+// included.
+func (s *Snapshot) Values() map[string]float64 {
+	values := make(map[string]float64, len(Metrics)+len(s.Derived))
+	for _, m := range Metrics {
+		values[m.Name] = m.Value(s)
+	}
+	for name, v := range s.Derived {
+		values[name] = v
+	}
+	return values
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func meanInt(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum int
+	for _, v := range values {
+		sum += v
+	}
+	return float64(sum) / float64(len(values))
+}
+
+func maxValue(values map[string]float64) float64 {
+	var hottest float64
+	for _, v := range values {
+		hottest = max(hottest, v)
+	}
+	return hottest
+}
@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/sirupsen/logrus"
+)
+
+// Source names where a snapshot field comes from. Every source is sampled
+// at its own rate and Snapshot.Updated records when each one last changed.
+type Source string
+
+const (
+	// SourcePower covers everything only powermetrics reports: CPU
+	// cluster residency and frequencies, GPU, ANE and power rails. On
+	// Linux it is the RAPL power rails and the GPU.
+	SourcePower Source = "power"
+	// SourceNetDisk is network and disk activity. It comes from
+	// powermetrics, or from system counters without root.
+	SourceNetDisk Source = "net_disk"
+	// SourceCPU is the usage of every core, only sampled without root or
+	// on Linux, where it also covers core frequencies and temperatures.
+	SourceCPU       Source = "cpu"
+	SourceMemory    Source = "memory"
+	SourceProcesses Source = "processes"
+	SourceDiskSpace Source = "disk_space"
+)
+
+// Sources lists every source.
+var Sources = []Source{SourcePower, SourceNetDisk, SourceCPU, SourceMemory, SourceProcesses, SourceDiskSpace}
+
+// update carries a sampled value from a poller to the merge loop.
+type update struct {
+	source Source
+	time   time.Time
+	apply  func(*Snapshot)
+}
+
+// sampleFunc samples a source and returns a function storing the result in
+// a snapshot.
+type sampleFunc func() (func(*Snapshot), error)
+
+// poll samples a source right away and then every interval until done is
+// closed. interval is read again after every sample so it may change at
+// runtime.
+func poll(done <-chan struct{}, updates chan<- update, source Source, interval func() time.Duration, sample sampleFunc) {
+	for {
+		apply, err := sample()
+		if err != nil {
+			logrus.Debugf("failed to sample %s: %v", source, err)
+		} else {
+			select {
+			case updates <- update{source: source, time: time.Now(), apply: apply}:
+			case <-done:
+				return
+			}
+		}
+
+		timer := time.NewTimer(interval())
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// every returns a fixed poll interval.
+func every(interval time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return interval
+	}
+}
+
+func sampleMemory() (func(*Snapshot), error) {
+	memory := parser.GetMemoryMetrics()
+	return func(s *Snapshot) {
+		s.Memory = memory
+	}, nil
+}
+
+func sampleDiskSpace(path string) sampleFunc {
+	return func() (func(*Snapshot), error) {
+		diskSpace, err := parser.GetDiskSpaceMetrics(path)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Snapshot) {
+			s.DiskSpace = diskSpace
+		}, nil
+	}
+}
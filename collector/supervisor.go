@@ -0,0 +1,490 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/runner"
+)
+
+// idleFlush is how long the output has to be quiet before the lines read so
+// far are treated as a complete sample. powermetrics writes a whole sample
+// at once and then sleeps for the rest of the interval.
+const idleFlush = 100 * time.Millisecond
+
+type State int
+
+const (
+	StateStarting State = iota
+	StateRunning
+	StateRestarting
+	StateFailed
+	// StateUnavailable means powermetrics is not used because mactop runs
+	// without root.
+	StateUnavailable
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateRestarting:
+		return "restarting"
+	case StateFailed:
+		return "failed"
+	case StateUnavailable:
+		return "unavailable"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for state := StateStarting; state <= StateUnavailable; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown collector state %q", text)
+}
+
+// Health describes the state of the powermetrics subprocess.
+type Health struct {
+	State      State
+	Restarts   int
+	LastError  string
+	LastSample time.Time
+}
+
+// Samplers lists the powermetrics samplers mactop knows how to use.
+var Samplers = []string{"cpu_power", "gpu_power", "thermal", "network", "disk", "battery", "interrupts"}
+
+// ProcessStats maps the per-process statistics mactop can request to the
+// powermetrics flag that enables them.
+var ProcessStats = []struct {
+	Name string
+	Flag string
+}{
+	{"gpu", "--show-process-gpu"},
+	{"energy", "--show-process-energy"},
+	{"netstats", "--show-process-netstats"},
+	{"io", "--show-process-io"},
+	{"coalition", "--show-process-coalition"},
+}
+
+var (
+	DefaultSamplers     = []string{"cpu_power", "gpu_power", "thermal", "network", "disk"}
+	DefaultProcessStats = []string{"gpu", "energy", "netstats"}
+)
+
+type Options struct {
+	// Interval is the powermetrics sample interval in milliseconds.
+	Interval int
+	// Samplers are passed to powermetrics --samplers.
+	Samplers []string
+	// ProcessStats enables per-process statistics. Leaving it empty turns
+	// off process-level sampling entirely.
+	ProcessStats []string
+	// StallIntervals is the number of intervals without a sample after
+	// which powermetrics is considered stalled and restarted.
+	StallIntervals int
+	// MinStallTimeout keeps short intervals from restarting powermetrics
+	// while it is still starting up.
+	MinStallTimeout time.Duration
+	MinBackoff      time.Duration
+	MaxBackoff      time.Duration
+	// MaxFailures is the number of consecutive failed runs after which the
+	// supervisor gives up. Zero retries forever.
+	MaxFailures int
+	// Runner starts powermetrics. Nil uses runner.Default.
+	Runner runner.Runner
+
+	// MemoryInterval, ProcessInterval and DiskSpaceInterval are the rates
+	// the slower sources are sampled at.
+	MemoryInterval    time.Duration
+	ProcessInterval   time.Duration
+	DiskSpaceInterval time.Duration
+	// DiskSpacePath is the mount point whose usage is reported.
+	DiskSpacePath string
+
+	History HistoryOptions
+	// StaleIntervals is the number of expected intervals after which a
+	// source without new samples is reported as stale.
+	StaleIntervals int
+	// Derived lists the metrics computed from the sampled ones.
+	Derived []Derived
+	// Unprivileged replaces powermetrics, which needs root, with system
+	// counters any user can read. Power, frequencies, GPU and ANE are not
+	// available in this mode.
+	Unprivileged bool
+	// Backend selects where metrics come from. Empty uses powermetrics.
+	Backend string
+	// SysRoot is the root of the /proc and /sys trees read by the linux
+	// backend. Empty means "/".
+	SysRoot string
+}
+
+const (
+	// BackendPowermetrics runs powermetrics on macOS.
+	BackendPowermetrics = "powermetrics"
+	// BackendLinux reads procfs and sysfs on Linux.
+	BackendLinux = "linux"
+)
+
+// Backends lists every backend.
+var Backends = []string{BackendPowermetrics, BackendLinux}
+
+func DefaultOptions(interval int) Options {
+	return Options{
+		Interval:        interval,
+		Samplers:        DefaultSamplers,
+		ProcessStats:    DefaultProcessStats,
+		StallIntervals:  5,
+		MinStallTimeout: 5 * time.Second,
+		MinBackoff:      time.Second,
+		MaxBackoff:      time.Minute,
+		MaxFailures:     0,
+
+		MemoryInterval:    time.Second,
+		ProcessInterval:   2 * time.Second,
+		DiskSpaceInterval: 30 * time.Second,
+		DiskSpacePath:     "/",
+
+		History:        DefaultHistoryOptions(),
+		StaleIntervals: 3,
+	}
+}
+
+// Validate reports unknown samplers or process statistics and invalid
+// derived metrics.
+func (o Options) Validate() error {
+	if o.Interval < MinInterval {
+		return fmt.Errorf("invalid interval %d, must be at least %d ms", o.Interval, MinInterval)
+	}
+	if o.Backend != "" && !slices.Contains(Backends, o.Backend) {
+		return fmt.Errorf("unknown backend %q, options are: %s", o.Backend, strings.Join(Backends, ", "))
+	}
+	if len(o.Samplers) == 0 {
+		return errors.New("at least one sampler must be enabled")
+	}
+	for _, sampler := range o.Samplers {
+		if !slices.Contains(Samplers, sampler) {
+			return fmt.Errorf("unknown sampler %q, options are: %s", sampler, strings.Join(Samplers, ", "))
+		}
+	}
+	for _, stat := range o.ProcessStats {
+		if processStatFlag(stat) == "" {
+			names := make([]string, 0, len(ProcessStats))
+			for _, p := range ProcessStats {
+				names = append(names, p.Name)
+			}
+			return fmt.Errorf("unknown process stat %q, options are: %s", stat, strings.Join(names, ", "))
+		}
+	}
+	if _, err := compileDerived(o.Derived); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MinInterval is the shortest sample interval mactop asks powermetrics for.
+const MinInterval = 100
+
+// errRestartRequested ends a run that is replaced by one with new options.
+var errRestartRequested = errors.New("restart requested")
+
+// NeedsRoot reports whether collecting with o needs root: powermetrics
+// always does, and the linux backend for its RAPL power counters, which it
+// reads whatever the samplers.
+func (o Options) NeedsRoot() bool {
+	return !o.Unprivileged
+}
+
+// Enabled reports whether the given sampler is turned on.
+func (o Options) Enabled(sampler string) bool {
+	return slices.Contains(o.Samplers, sampler)
+}
+
+// Supervisor runs powermetrics, parses its output into samples and restarts
+// it with exponential backoff when it exits or stops producing samples.
+type Supervisor struct {
+	modelName string
+	restart   chan struct{}
+
+	mu     sync.Mutex
+	opts   Options
+	health Health
+}
+
+func NewSupervisor(modelName string, opts Options) *Supervisor {
+	health := Health{State: StateStarting}
+	switch {
+	case opts.Backend == BackendLinux:
+		health = Health{State: StateUnavailable, LastError: "powermetrics is not used on Linux"}
+	case opts.Unprivileged:
+		health = Health{State: StateUnavailable, LastError: "powermetrics requires root"}
+	}
+	return &Supervisor{
+		modelName: modelName,
+		restart:   make(chan struct{}, 1),
+		opts:      opts,
+		health:    health,
+	}
+}
+
+// Health returns the current health of the collector.
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// Interval returns the sample interval in milliseconds.
+func (s *Supervisor) Interval() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.Interval
+}
+
+// SetInterval changes the sample interval. powermetrics is restarted with
+// the new interval right away; this does not count as a failure.
+func (s *Supervisor) SetInterval(interval int) error {
+	if interval < MinInterval {
+		return fmt.Errorf("invalid interval %d, must be at least %d ms", interval, MinInterval)
+	}
+	s.mu.Lock()
+	changed := s.opts.Interval != interval
+	s.opts.Interval = interval
+	s.mu.Unlock()
+
+	if changed {
+		select {
+		case s.restart <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *Supervisor) options() Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts
+}
+
+// Run supervises powermetrics until done is closed. Every parsed sample is
+// sent on samples and every health change on health.
+func (s *Supervisor) Run(done <-chan struct{}, samples chan<- parser.Sample, health chan<- Health) {
+	opts := s.options()
+	backoff := opts.MinBackoff
+	failures := 0
+
+	for {
+		gotSample, err := s.runOnce(done, samples, health)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if errors.Is(err, errRestartRequested) {
+			continue
+		}
+
+		if gotSample {
+			failures = 0
+			backoff = opts.MinBackoff
+		}
+		failures++
+
+		if opts.MaxFailures > 0 && failures >= opts.MaxFailures {
+			s.publish(done, health, func(h *Health) {
+				h.State = StateFailed
+				h.LastError = err.Error()
+			})
+			return
+		}
+
+		s.publish(done, health, func(h *Health) {
+			h.State = StateRestarting
+			h.LastError = err.Error()
+		})
+
+		select {
+		case <-done:
+			return
+		case <-s.restart:
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, opts.MaxBackoff)
+
+		s.publish(done, health, func(h *Health) {
+			h.State = StateStarting
+			h.Restarts++
+		})
+	}
+}
+
+// runOnce starts powermetrics and reads samples from it until it exits,
+// stalls or done is closed. It reports whether at least one sample was read.
+func (s *Supervisor) runOnce(done <-chan struct{}, samples chan<- parser.Sample, health chan<- Health) (bool, error) {
+	// A pending restart request is already satisfied by this run.
+	select {
+	case <-s.restart:
+	default:
+	}
+	opts := s.options()
+
+	cmd := runner.OrDefault(opts.Runner).Command("powermetrics", powermetricsArgs(opts)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("failed to start powermetrics: %w", err)
+	}
+
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	kill := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+
+	stallTimeout := max(time.Duration(opts.StallIntervals*opts.Interval)*time.Millisecond, opts.MinStallTimeout)
+	stall := time.NewTimer(stallTimeout)
+	defer stall.Stop()
+	idle := time.NewTimer(idleFlush)
+	idle.Stop()
+	defer idle.Stop()
+
+	sampleParser := parser.NewSampleParser(s.modelName)
+	gotSample := false
+	emit := func(sample parser.Sample) bool {
+		gotSample = true
+		resetTimer(stall, stallTimeout)
+		s.publish(done, health, func(h *Health) {
+			h.State = StateRunning
+			h.LastSample = time.Now()
+		})
+		select {
+		case samples <- sample:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			kill()
+			return gotSample, nil
+		case line, ok := <-lines:
+			if !ok {
+				if sample, ok := sampleParser.Flush(); ok {
+					emit(sample)
+				}
+				err := cmd.Wait()
+				if err == nil {
+					err = errors.New("exited")
+				}
+				return gotSample, fmt.Errorf("powermetrics: %w", err)
+			}
+			if sample, ok := sampleParser.ParseLine(line); ok {
+				if !emit(sample) {
+					kill()
+					return gotSample, nil
+				}
+			}
+			resetTimer(idle, idleFlush)
+		case <-idle.C:
+			if sample, ok := sampleParser.Flush(); ok {
+				if !emit(sample) {
+					kill()
+					return gotSample, nil
+				}
+			}
+		case <-s.restart:
+			kill()
+			return gotSample, errRestartRequested
+		case <-stall.C:
+			kill()
+			return gotSample, fmt.Errorf("powermetrics stalled: no sample within %s", stallTimeout)
+		}
+	}
+}
+
+// resetTimer resets t to d, dropping a value it sent but nobody read. Before
+// Go 1.23 timer semantics, which this module does not opt into, Reset
+// leaves that value in the channel, where it would flush a half-read
+// sample or restart a healthy powermetrics.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// publish applies update to the health state and sends the result on
+// health, unless the state did not change.
+func (s *Supervisor) publish(done <-chan struct{}, health chan<- Health, update func(h *Health)) {
+	s.mu.Lock()
+	before := s.health
+	update(&s.health)
+	h := s.health
+	s.mu.Unlock()
+
+	if before.State == h.State && before.Restarts == h.Restarts && before.LastError == h.LastError {
+		return
+	}
+	select {
+	case health <- h:
+	case <-done:
+	}
+}
+
+func powermetricsArgs(opts Options) []string {
+	args := []string{"--samplers", strings.Join(opts.Samplers, ",")}
+	for _, stat := range opts.ProcessStats {
+		args = append(args, processStatFlag(stat))
+	}
+	return append(args, "--show-initial-usage", "-i", strconv.Itoa(opts.Interval))
+}
+
+func processStatFlag(name string) string {
+	for _, p := range ProcessStats {
+		if p.Name == name {
+			return p.Flag
+		}
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"errors"
+	"time"
+
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// The samplers below replace powermetrics when mactop runs without root.
+// They only need counters every user can read, and report rates computed
+// from the difference between two readings, so the first call of each
+// only takes a baseline.
+
+var errBaseline = errors.New("waiting for a second reading")
+
+// sampleCores reports the usage of every logical core in percent.
+func sampleCores() sampleFunc {
+	var last []cpu.TimesStat
+	return func() (func(*Snapshot), error) {
+		times, err := cpu.Times(true)
+		if err != nil {
+			return nil, err
+		}
+		prev := last
+		last = times
+		if len(prev) != len(times) {
+			return nil, errBaseline
+		}
+
+		usage := make([]float64, len(times))
+		for i, t := range times {
+			total := t.Total() - prev[i].Total()
+			idle := (t.Idle + t.Iowait) - (prev[i].Idle + prev[i].Iowait)
+			if total > 0 {
+				usage[i] = min(max((total-idle)/total*100, 0), 100)
+			}
+		}
+		return func(s *Snapshot) {
+			s.CoreUsage = usage
+		}, nil
+	}
+}
+
+type netDiskCounters struct {
+	time                                           time.Time
+	packetsSent, bytesSent, packetsRecv, bytesRecv uint64
+	readCount, readBytes, writeCount, writeBytes   uint64
+}
+
+// sampleNetDisk reports network and disk activity summed over every
+// interface and disk.
+func sampleNetDisk() sampleFunc {
+	var last *netDiskCounters
+	return func() (func(*Snapshot), error) {
+		c := netDiskCounters{time: time.Now()}
+		nets, err := net.IOCounters(false)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nets {
+			c.packetsSent += n.PacketsSent
+			c.bytesSent += n.BytesSent
+			c.packetsRecv += n.PacketsRecv
+			c.bytesRecv += n.BytesRecv
+		}
+		disks, err := disk.IOCounters()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range disks {
+			c.readCount += d.ReadCount
+			c.readBytes += d.ReadBytes
+			c.writeCount += d.WriteCount
+			c.writeBytes += d.WriteBytes
+		}
+
+		prev := last
+		last = &c
+		if prev == nil {
+			return nil, errBaseline
+		}
+		seconds := c.time.Sub(prev.time).Seconds()
+		rate := func(now, before uint64) float64 {
+			if now < before || seconds <= 0 {
+				return 0
+			}
+			return float64(now-before) / seconds
+		}
+		metrics := parser.NetDiskMetrics{
+			OutPacketsPerSec:  rate(c.packetsSent, prev.packetsSent),
+			OutBytesPerSec:    rate(c.bytesSent, prev.bytesSent),
+			InPacketsPerSec:   rate(c.packetsRecv, prev.packetsRecv),
+			InBytesPerSec:     rate(c.bytesRecv, prev.bytesRecv),
+			ReadOpsPerSec:     rate(c.readCount, prev.readCount),
+			ReadKBytesPerSec:  rate(c.readBytes, prev.readBytes) / 1024,
+			WriteOpsPerSec:    rate(c.writeCount, prev.writeCount),
+			WriteKBytesPerSec: rate(c.writeBytes, prev.writeBytes) / 1024,
+		}
+		return func(s *Snapshot) {
+			s.NetDisk = metrics
+		}, nil
+	}
+}
+
+// sampleProcesses reports the CPU time every process used since the last
+// call in ms/s, the unit powermetrics uses.
+func sampleProcesses() sampleFunc {
+	type reading struct {
+		time time.Time
+		cpu  float64
+	}
+	last := make(map[int32]reading)
+	return func() (func(*Snapshot), error) {
+		procs, err := process.Processes()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		next := make(map[int32]reading, len(procs))
+		var metrics []parser.ProcessMetrics
+		for _, p := range procs {
+			times, err := p.Times()
+			if err != nil {
+				continue
+			}
+			r := reading{time: now, cpu: times.User + times.System}
+			next[p.Pid] = r
+			prev, ok := last[p.Pid]
+			if !ok || r.cpu < prev.cpu {
+				continue
+			}
+			name, err := p.Name()
+			if err != nil {
+				continue
+			}
+			metrics = append(metrics, parser.ProcessMetrics{
+				ID:       int(p.Pid),
+				Name:     name,
+				CPUUsage: (r.cpu - prev.cpu) * 1000 / now.Sub(prev.time).Seconds(),
+			})
+		}
+		first := len(last) == 0
+		last = next
+		if first {
+			return nil, errBaseline
+		}
+		return func(s *Snapshot) {
+			s.Processes = metrics
+		}, nil
+	}
+}
@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnprivilegedSamplers(t *testing.T) {
+	for source, sample := range map[Source]sampleFunc{
+		SourceCPU:       sampleCores(),
+		SourceNetDisk:   sampleNetDisk(),
+		SourceProcesses: sampleProcesses(),
+	} {
+		if _, err := sample(); !errors.Is(err, errBaseline) {
+			t.Errorf("%s: expected the first reading to be a baseline, got %v", source, err)
+			continue
+		}
+		time.Sleep(20 * time.Millisecond)
+		apply, err := sample()
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+			continue
+		}
+		var s Snapshot
+		apply(&s)
+		switch source {
+		case SourceCPU:
+			if len(s.CoreUsage) == 0 {
+				t.Error("expected per-core usage")
+			}
+		case SourceProcesses:
+			if len(s.Processes) == 0 {
+				t.Error("expected processes")
+			}
+		}
+	}
+}
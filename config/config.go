@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the settings that can be stored in the config file. Zero
+// values mean "not set" and leave the command line defaults in place.
+type Config struct {
+	Interval     int       `json:"interval,omitempty"`
+	Color        string    `json:"color,omitempty"`
+	Samplers     []string  `json:"samplers,omitempty"`
+	ProcessStats []string  `json:"process_stats,omitempty"`
+	Derived      []Derived `json:"derived,omitempty"`
+	Backend      string    `json:"backend,omitempty"`
+}
+
+// Derived is a metric computed from other metrics, for example
+// {"name": "gpu_share", "expr": "GPUW / PackageW"}.
+type Derived struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+	Unit string `json:"unit,omitempty"`
+	Help string `json:"help,omitempty"`
+}
+
+// DefaultPath returns $XDG_CONFIG_HOME/mactop/config.json, falling back to
+// ~/.config/mactop/config.json.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "mactop", "config.json")
+}
+
+// Load reads the config file at path. A missing file is only an error when
+// mustExist is set, otherwise an empty Config is returned.
+func Load(path string, mustExist bool) (*Config, error) {
+	var cfg Config
+	if path == "" {
+		return &cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !mustExist {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
@@ -1,8 +1,12 @@
 package event_throttler
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type EventThrottler struct {
+	mu          sync.Mutex
 	timer       *time.Timer
 	gracePeriod time.Duration
 
@@ -17,13 +21,24 @@ func NewEventThrottler(gracePeriod time.Duration) *EventThrottler {
 	}
 }
 
+// SetGracePeriod changes the grace period for events notified from now on.
+func (e *EventThrottler) SetGracePeriod(gracePeriod time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.gracePeriod = gracePeriod
+}
+
 func (e *EventThrottler) Notify() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if e.timer != nil {
 		return
 	}
 
 	e.timer = time.AfterFunc(e.gracePeriod, func() {
+		e.mu.Lock()
 		e.timer = nil
+		e.mu.Unlock()
 		select {
 		case e.C <- struct{}{}:
 		default:
@@ -0,0 +1,206 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+)
+
+// csvTimeFormat is used for the time column and the names of rotated files.
+const csvTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type CSVOptions struct {
+	// Path receives a row per snapshot: the time, then every metric of
+	// collector.Metrics and StatusMetrics in order, then the derived
+	// metrics. Empty turns it off.
+	Path string
+	// ProcessPath receives a row per process and snapshot. Empty turns
+	// it off.
+	ProcessPath string
+	// MaxSize and MaxAge start a new file once the current one reached
+	// that many bytes or holds rows that far apart. Zero never rotates.
+	// Rotated files keep the name with the time of their first row
+	// appended.
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// ProcessColumns are the columns of the process CSV.
+var ProcessColumns = []string{"time", "pid", "name", "cpu_ms_per_s"}
+
+// MetricColumns returns the columns of the metrics CSV.
+func MetricColumns(derived []string) []string {
+	columns := []string{"time"}
+	for _, m := range sinkMetrics() {
+		columns = append(columns, m.Name)
+	}
+	return append(columns, derived...)
+}
+
+type csvSink struct {
+	derived   []string
+	metrics   *rotatingCSV
+	processes *rotatingCSV
+}
+
+// NewCSV returns a sink writing the files of opts. Files are created with
+// the first snapshot, and one that already exists is rotated away first so
+// that every file starts with its header.
+func NewCSV(opts CSVOptions, derived []string) Sink {
+	sink := &csvSink{derived: derived}
+	if opts.Path != "" {
+		sink.metrics = &rotatingCSV{path: opts.Path, header: MetricColumns(derived), maxSize: opts.MaxSize, maxAge: opts.MaxAge}
+	}
+	if opts.ProcessPath != "" {
+		sink.processes = &rotatingCSV{path: opts.ProcessPath, header: ProcessColumns, maxSize: opts.MaxSize, maxAge: opts.MaxAge}
+	}
+	return sink
+}
+
+func (c *csvSink) Write(s *collector.Snapshot) error {
+	now := s.Time.Format(csvTimeFormat)
+	var errs []error
+	if c.metrics != nil {
+		row := []string{now}
+		for _, m := range sinkMetrics() {
+			row = append(row, formatFloat(m.Value(s)))
+		}
+		for _, name := range c.derived {
+			// Derived metrics that could not be computed are left empty.
+			if v, ok := s.Derived[name]; ok {
+				row = append(row, formatFloat(v))
+			} else {
+				row = append(row, "")
+			}
+		}
+		errs = append(errs, c.metrics.write(s.Time, [][]string{row}))
+	}
+	if c.processes != nil && len(s.Processes) > 0 {
+		rows := make([][]string, 0, len(s.Processes))
+		for _, p := range s.Processes {
+			rows = append(rows, []string{now, strconv.Itoa(p.ID), p.Name, formatFloat(p.CPUUsage)})
+		}
+		errs = append(errs, c.processes.write(s.Time, rows))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *csvSink) Close() error {
+	var errs []error
+	for _, f := range []*rotatingCSV{c.metrics, c.processes} {
+		if f != nil {
+			errs = append(errs, f.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// rotatingCSV is a CSV file that is moved aside once it is too large or
+// too old. Rows are flushed as they are written, so the file stays
+// readable while mactop runs and when it is killed.
+type rotatingCSV struct {
+	path    string
+	header  []string
+	maxSize int64
+	maxAge  time.Duration
+
+	file  *os.File
+	out   *countingWriter
+	w     *csv.Writer
+	first time.Time
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (r *rotatingCSV) write(t time.Time, rows [][]string) error {
+	if r.file != nil && r.due(t) {
+		if err := r.close(); err != nil {
+			return err
+		}
+		if err := rotate(r.path, r.first); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(t); err != nil {
+			return err
+		}
+	}
+	if err := r.w.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.path, err)
+	}
+	return nil
+}
+
+func (r *rotatingCSV) due(t time.Time) bool {
+	return (r.maxSize > 0 && r.out.n >= r.maxSize) || (r.maxAge > 0 && t.Sub(r.first) >= r.maxAge)
+}
+
+func (r *rotatingCSV) open(t time.Time) error {
+	if info, err := os.Stat(r.path); err == nil && info.Size() > 0 {
+		if err := rotate(r.path, info.ModTime()); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", r.path, err)
+	}
+	r.file = file
+	r.out = &countingWriter{w: file}
+	r.w = csv.NewWriter(r.out)
+	r.first = t
+	if err := r.w.Write(r.header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.path, err)
+	}
+	return nil
+}
+
+func (r *rotatingCSV) close() error {
+	if r.file == nil {
+		return nil
+	}
+	r.w.Flush()
+	err := errors.Join(r.w.Error(), r.file.Close())
+	r.file = nil
+	return err
+}
+
+// rotate moves path aside to a name carrying t, such as
+// power-2024-05-01T22-00-00.csv for power.csv.
+func rotate(path string, t time.Time) error {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + t.Format("2006-01-02T15-04-05")
+	target := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to rotate %s: %w", path, err)
+	}
+	return nil
+}
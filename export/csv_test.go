@@ -0,0 +1,144 @@
+package export
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+var start = time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)
+
+func snapshotAt(seconds int) *collector.Snapshot {
+	return &collector.Snapshot{
+		Time: start.Add(time.Duration(seconds) * time.Second),
+		CPU:  parser.CPUMetrics{CPUW: 1.5, PackageW: 2.25},
+		Processes: []parser.ProcessMetrics{
+			{ID: 42, Name: "Xcode, Helper", CPUUsage: 120.5},
+			{ID: 7, Name: "launchd", CPUUsage: 1},
+		},
+		Derived: map[string]float64{"GPUShare": 0.5},
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return records
+}
+
+func TestCSV(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewCSV(CSVOptions{
+		Path:        filepath.Join(dir, "power.csv"),
+		ProcessPath: filepath.Join(dir, "processes.csv"),
+	}, []string{"GPUShare", "Missing"})
+	for i := 0; i < 3; i++ {
+		s := snapshotAt(i)
+		s.Stale = i == 1
+		if err := sink.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCSV(t, filepath.Join(dir, "power.csv"))
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want a header and 3 rows", len(rows))
+	}
+	header := rows[0]
+	if header[0] != "time" || !slices.Equal(header[len(header)-2:], []string{"GPUShare", "Missing"}) || len(header) != len(collector.Metrics)+len(StatusMetrics)+3 {
+		t.Errorf("unexpected header %q", header)
+	}
+	row := rows[1]
+	if row[0] != "2024-05-01T22:00:00.000Z" {
+		t.Errorf("got time %q", row[0])
+	}
+	column := func(name string) string {
+		return row[slices.Index(header, name)]
+	}
+	if column("CPUW") != "1.5" || column("PackageW") != "2.25" || column("GPUShare") != "0.5" || column("Missing") != "" || column("Stale") != "0" {
+		t.Errorf("unexpected row %q", row)
+	}
+	if stale := rows[2][slices.Index(header, "Stale")]; stale != "1" {
+		t.Errorf("got Stale %q for a stale snapshot", stale)
+	}
+
+	processes := readCSV(t, filepath.Join(dir, "processes.csv"))
+	if len(processes) != 7 || !slices.Equal(processes[0], ProcessColumns) {
+		t.Fatalf("unexpected process rows %q", processes)
+	}
+	if !slices.Equal(processes[1], []string{"2024-05-01T22:00:00.000Z", "42", "Xcode, Helper", "120.5"}) {
+		t.Errorf("unexpected process row %q", processes[1])
+	}
+}
+
+func TestCSVRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "power.csv")
+	if err := os.WriteFile(path, []byte("from an earlier run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := NewCSV(CSVOptions{Path: path, MaxAge: time.Minute}, nil)
+	for i := 0; i < 150; i += 10 {
+		if err := sink.Write(snapshotAt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "power*.csv"))
+	// The earlier file, two full minutes and the current file.
+	if len(files) != 4 {
+		t.Fatalf("got files %q, want 4", files)
+	}
+	first := readCSV(t, filepath.Join(dir, "power-2024-05-01T22-00-00.csv"))
+	if len(first) != 7 || first[0][0] != "time" {
+		t.Errorf("got %d rows in the first minute, want a header and 6 rows", len(first))
+	}
+	if rows := readCSV(t, path); len(rows) != 4 || rows[1][0] != "2024-05-01T22:02:00.000Z" {
+		t.Errorf("unexpected current file %q", rows)
+	}
+}
+
+func TestCSVRotationBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "power.csv")
+	sink := NewCSV(CSVOptions{Path: path, MaxSize: 1}, nil)
+	// Every row falls into the same second, so the second rotated file
+	// gets a suffix.
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(snapshotAt(0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "power*.csv"))
+	want := []string{path, filepath.Join(dir, "power-2024-05-01T22-00-00.1.csv"), filepath.Join(dir, "power-2024-05-01T22-00-00.csv")}
+	slices.Sort(want)
+	if !slices.Equal(files, want) {
+		t.Errorf("got files %q, want %q", files, want)
+	}
+	for _, file := range files {
+		if rows := readCSV(t, file); len(rows) != 2 {
+			t.Errorf("%s has %d rows, want a header and 1 row", file, len(rows))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package export writes snapshots to files and monitoring systems, next to
+// the UI or headless output.
+package export
+
+import (
+	"errors"
+	"os"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+// Sink receives every snapshot. Write is called from a single goroutine;
+// Close is called once the snapshots stop.
+type Sink interface {
+	Write(s *collector.Snapshot) error
+	Close() error
+}
+
+// Options selects the sinks to open. The zero value opens none.
+type Options struct {
+	CSV        CSVOptions
+	Prometheus PrometheusOptions
+	Influx     InfluxOptions
+	StatsD     StatsDOptions
+	OTLP       OTLPOptions
+	// Host tags the metrics of sinks that leave the machine. Empty uses
+	// the hostname.
+	Host string
+	// Version is the mactop version reported by OTLP.
+	Version string
+	// Derived lists the derived metrics configured in the collector, in
+	// order. Sinks with a fixed set of columns append them to the
+	// built-in metrics.
+	Derived []string
+}
+
+// Open creates every sink enabled in opts. socInfo describes the machine
+// the snapshots come from.
+func Open(opts Options, socInfo *soc.SocInfo) ([]Sink, error) {
+	var sinks []Sink
+	if opts.CSV.Path != "" || opts.CSV.ProcessPath != "" {
+		sinks = append(sinks, NewCSV(opts.CSV, opts.Derived))
+	}
+	if opts.Prometheus.Listen != "" {
+		p, err := NewPrometheus(opts.Prometheus, socInfo)
+		if err != nil {
+			CloseAll(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, p)
+	}
+	if opts.Influx.Target != "" {
+		influx, err := NewInflux(opts.Influx, socInfo, host(opts.Host))
+		if err != nil {
+			CloseAll(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, influx)
+	}
+	if opts.StatsD.Addr != "" {
+		statsd, err := NewStatsD(opts.StatsD, socInfo, host(opts.Host))
+		if err != nil {
+			CloseAll(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, statsd)
+	}
+	if opts.OTLP.Endpoint != "" {
+		otlp, err := NewOTLP(opts.OTLP, socInfo, host(opts.Host), opts.Version)
+		if err != nil {
+			CloseAll(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, otlp)
+	}
+	return sinks, nil
+}
+
+func host(name string) string {
+	if name != "" {
+		return name
+	}
+	name, _ = os.Hostname()
+	return name
+}
+
+// CloseAll closes every sink and returns their errors.
+func CloseAll(sinks []Sink) error {
+	var errs []error
+	for _, sink := range sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
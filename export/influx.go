@@ -0,0 +1,365 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/soc"
+	"github.com/sirupsen/logrus"
+)
+
+type InfluxOptions struct {
+	// Target is an http(s) URL to POST to, a file to append to, or "-"
+	// for stdout. URLs without a path get /api/v2/write; the org and
+	// bucket go into the query, e.g.
+	// http://localhost:8086?org=lab&bucket=mactop. Empty turns it off.
+	Target string
+	// Token is sent as the InfluxDB API token.
+	Token string
+	// BatchSize is the number of lines sent per request, and
+	// FlushInterval how long lines wait for a batch to fill up.
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxBuffer bounds the lines kept while the endpoint is down. The
+	// oldest lines are dropped first.
+	MaxBuffer int
+	// RetryInterval is the first wait after a failed request. It doubles
+	// with every failure up to a minute.
+	RetryInterval time.Duration
+}
+
+func (o *InfluxOptions) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 10 * time.Second
+	}
+	if o.MaxBuffer <= 0 {
+		o.MaxBuffer = 100000
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+}
+
+const maxInfluxRetryInterval = time.Minute
+
+// NewInflux returns a sink writing line protocol to opts.Target. Every
+// line is tagged with the chip, its core counts and host.
+func NewInflux(opts InfluxOptions, socInfo *soc.SocInfo, host string) (Sink, error) {
+	opts.setDefaults()
+	encoder := newInfluxEncoder(socInfo, host)
+	if !strings.HasPrefix(opts.Target, "http://") && !strings.HasPrefix(opts.Target, "https://") {
+		return newInfluxFile(opts.Target, encoder)
+	}
+	endpoint, err := url.Parse(opts.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %w", err)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/api/v2/write"
+	}
+	query := endpoint.Query()
+	query.Set("precision", "ns")
+	endpoint.RawQuery = query.Encode()
+	i := &influxHTTP{
+		opts:     opts,
+		endpoint: endpoint.String(),
+		encoder:  encoder,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go i.run()
+	return i, nil
+}
+
+// influxEncoder turns snapshots into line protocol. The mactop measurement
+// has every metric of collector.Metrics, StatusMetrics and the derived
+// metrics as fields; mactop_cluster and mactop_core have the values of
+// each cluster and core.
+type influxEncoder struct {
+	tags string
+}
+
+func newInfluxEncoder(socInfo *soc.SocInfo, host string) influxEncoder {
+	tags := []string{
+		"chip", socInfo.Name,
+		"host", host,
+		"model", socInfo.ModelIdentifier,
+		"e_cores", strconv.Itoa(socInfo.ECoreCount),
+		"p_cores", strconv.Itoa(socInfo.PCoreCount),
+		"gpu_cores", strconv.Itoa(socInfo.GpuCoreCount),
+	}
+	return influxEncoder{tags: influxTags(tags...)}
+}
+
+// influxTags formats name, value pairs as tags, skipping empty values,
+// which line protocol does not allow.
+func influxTags(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(influxEscaper.Replace(pairs[i]))
+		b.WriteByte('=')
+		b.WriteString(influxEscaper.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
+
+var influxEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+
+func (e influxEncoder) encode(s *collector.Snapshot) [][]byte {
+	ts := strconv.FormatInt(s.Time.UnixNano(), 10)
+	line := func(measurement, tags string, fields []string) []byte {
+		return []byte(measurement + e.tags + tags + " " + strings.Join(fields, ",") + " " + ts + "\n")
+	}
+	field := func(name string, v float64) string {
+		return influxEscaper.Replace(name) + "=" + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	var fields []string
+	for _, m := range sinkMetrics() {
+		fields = append(fields, field(m.Name, m.Value(s)))
+	}
+	for _, name := range sortedKeys(s.Derived) {
+		fields = append(fields, field(name, s.Derived[name]))
+	}
+	lines := [][]byte{line("mactop", "", fields)}
+
+	for _, c := range Clusters(&s.CPU) {
+		lines = append(lines, line("mactop_cluster", influxTags("cluster", c.Name),
+			[]string{field("active", float64(c.Active)), field("freq_mhz", float64(c.FreqMHz))}))
+	}
+	for core := range max(len(s.CoreUsage), len(s.CoreFreqMHz)) {
+		var coreFields []string
+		if core < len(s.CoreUsage) {
+			coreFields = append(coreFields, field("usage", s.CoreUsage[core]))
+		}
+		if core < len(s.CoreFreqMHz) {
+			coreFields = append(coreFields, field("freq_mhz", float64(s.CoreFreqMHz[core])))
+		}
+		lines = append(lines, line("mactop_core", influxTags(coreLabels(&s.CPU, core)...), coreFields))
+	}
+	return lines
+}
+
+// influxFile writes every snapshot as soon as it arrives.
+type influxFile struct {
+	encoder influxEncoder
+	file    io.WriteCloser
+	w       *bufio.Writer
+}
+
+func newInfluxFile(path string, encoder influxEncoder) (*influxFile, error) {
+	var file io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		file = f
+	}
+	return &influxFile{encoder: encoder, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (f *influxFile) Write(s *collector.Snapshot) error {
+	for _, line := range f.encoder.encode(s) {
+		f.w.Write(line)
+	}
+	return f.w.Flush()
+}
+
+func (f *influxFile) Close() error {
+	f.w.Flush()
+	return f.file.Close()
+}
+
+// influxHTTP buffers lines and POSTs them in batches from its own
+// goroutine, so a slow or unreachable endpoint never holds up snapshots.
+type influxHTTP struct {
+	opts     InfluxOptions
+	endpoint string
+	encoder  influxEncoder
+	client   *http.Client
+
+	mu     sync.Mutex
+	buffer [][]byte
+	// removed counts the lines taken off the front of the buffer, sent
+	// or dropped, and dropped the ones dropped since the last warning.
+	removed int
+	dropped int
+
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (i *influxHTTP) Write(s *collector.Snapshot) error {
+	i.mu.Lock()
+	i.buffer = append(i.buffer, i.encoder.encode(s)...)
+	if over := len(i.buffer) - i.opts.MaxBuffer; over > 0 {
+		i.buffer = i.buffer[over:]
+		i.removed += over
+		i.dropped += over
+	}
+	full := len(i.buffer) >= i.opts.BatchSize
+	i.mu.Unlock()
+	if full {
+		select {
+		case i.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close sends what is left in the buffer, giving up after a few seconds.
+func (i *influxHTTP) Close() error {
+	close(i.done)
+	<-i.stopped
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.buffer) > 0 {
+		return fmt.Errorf("influx: %d lines were not sent", len(i.buffer))
+	}
+	return nil
+}
+
+func (i *influxHTTP) run() {
+	defer close(i.stopped)
+	ticker := time.NewTicker(i.opts.FlushInterval)
+	defer ticker.Stop()
+	var retryAt time.Time
+	backoff := i.opts.RetryInterval
+	for {
+		select {
+		case <-i.done:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for i.pending() > 0 {
+				if err := i.flush(ctx); err != nil {
+					return
+				}
+			}
+			return
+		case <-ticker.C:
+		case <-i.kick:
+		}
+		if time.Now().Before(retryAt) {
+			continue
+		}
+		for i.pending() > 0 {
+			if err := i.flush(context.Background()); err != nil {
+				logrus.Warnf("influx: %v, retrying in %s", err, backoff)
+				retryAt = time.Now().Add(backoff)
+				backoff = min(2*backoff, maxInfluxRetryInterval)
+				break
+			}
+			backoff = i.opts.RetryInterval
+			i.reportDropped()
+			if i.pending() < i.opts.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (i *influxHTTP) pending() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.buffer)
+}
+
+func (i *influxHTTP) reportDropped() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.dropped > 0 {
+		logrus.Warnf("influx: dropped %d lines while the endpoint was down", i.dropped)
+		i.dropped = 0
+	}
+}
+
+// flush sends one batch. The batch stays in the buffer unless it was
+// accepted or rejected for good.
+func (i *influxHTTP) flush(ctx context.Context) error {
+	i.mu.Lock()
+	batch := i.buffer[:min(len(i.buffer), i.opts.BatchSize)]
+	removed := i.removed
+	i.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := i.post(ctx, bytes.Join(batch, nil))
+	if err != nil {
+		if _, rejected := err.(*influxRejected); !rejected {
+			return err
+		}
+		logrus.Errorf("influx: %v", err)
+	}
+	i.mu.Lock()
+	// Lines dropped from the front of the buffer while posting were part
+	// of the batch.
+	if left := len(batch) - (i.removed - removed); left > 0 {
+		i.buffer = i.buffer[left:]
+		i.removed += left
+	}
+	i.mu.Unlock()
+	return nil
+}
+
+// influxRejected is an error response that retrying will not fix, such as
+// a bad token or bucket.
+type influxRejected struct {
+	status string
+	body   string
+}
+
+func (e *influxRejected) Error() string {
+	return fmt.Sprintf("InfluxDB rejected the batch: %s %s", e.status, e.body)
+}
+
+func (i *influxHTTP) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+i.opts.Token)
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("InfluxDB returned %s", resp.Status)
+	}
+	return &influxRejected{status: resp.Status, body: strings.TrimSpace(string(message))}
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+)
+
+func TestInfluxLines(t *testing.T) {
+	encoder := newInfluxEncoder(m1Pro, "build farm,01")
+	s := powerSnapshot(0)
+	s.Derived = map[string]float64{"GPUShare": 0.25}
+	s.Health = collector.Health{State: collector.StateRestarting, Restarts: 3}
+	lines := encoder.encode(s)
+
+	// mactop, E, P0 and P1 clusters, three cores.
+	if len(lines) != 7 {
+		t.Fatalf("got %d lines, want 7:\n%s", len(lines), lines)
+	}
+	main := string(lines[0])
+	prefix := `mactop,chip=Apple\ M1\ Pro,host=build\ farm\,01,e_cores=2,p_cores=8,gpu_cores=16 `
+	if !strings.HasPrefix(main, prefix) || !strings.HasSuffix(main, " 1714600800000000000\n") {
+		t.Errorf("unexpected line %q", main)
+	}
+	for _, field := range []string{"CPUW=2", "PackageW=3.5", "ThermalPressure=1", "Stale=0", "PowermetricsHealthy=0", "PowermetricsRestarts=3", "GPUShare=0.25"} {
+		if !strings.Contains(main, field) {
+			t.Errorf("missing field %s in %q", field, main)
+		}
+	}
+	if got := string(lines[2]); !strings.HasPrefix(got, "mactop_cluster,chip=") || !strings.Contains(got, ",cluster=P0 active=12,freq_mhz=1241 ") {
+		t.Errorf("unexpected cluster line %q", got)
+	}
+	if got := string(lines[4]); !strings.Contains(got, ",core=0,cluster=E usage=50 ") {
+		t.Errorf("unexpected core line %q", got)
+	}
+}
+
+func TestInfluxFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mactop.lp")
+	sink, err := NewInflux(InfluxOptions{Target: path}, m1Pro, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(powerSnapshot(0))
+	sink.Write(powerSnapshot(1))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\nmactop,"); n != 1 || !strings.HasPrefix(string(data), "mactop,") {
+		t.Errorf("expected two mactop lines in:\n%s", data)
+	}
+}
+
+// influxStub records the bodies it accepts and fails while down is set.
+type influxStub struct {
+	mu       sync.Mutex
+	down     bool
+	requests int
+	lines    []string
+	query    string
+	auth     string
+}
+
+func (s *influxStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.query = r.URL.Path + "?" + r.URL.RawQuery
+	s.auth = r.Header.Get("Authorization")
+	if s.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.lines = append(s.lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *influxStub) count(measurement string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, line := range s.lines {
+		if strings.HasPrefix(line, measurement+",") {
+			n++
+		}
+	}
+	return n
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInfluxHTTPRetries(t *testing.T) {
+	stub := &influxStub{down: true}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	sink, err := NewInflux(InfluxOptions{
+		Target:        server.URL + "?org=lab&bucket=mactop",
+		Token:         "secret",
+		BatchSize:     7,
+		FlushInterval: 5 * time.Millisecond,
+		RetryInterval: 5 * time.Millisecond,
+		// Three snapshots of seven lines.
+		MaxBuffer: 21,
+	}, m1Pro, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		sink.Write(powerSnapshot(i))
+	}
+	waitFor(t, "a failed request", func() bool {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		return stub.requests > 0
+	})
+	stub.mu.Lock()
+	stub.down = false
+	stub.mu.Unlock()
+
+	// The two oldest snapshots were dropped from the full buffer.
+	waitFor(t, "the buffered snapshots", func() bool { return stub.count("mactop") == 3 })
+	sink.Write(powerSnapshot(5))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.count("mactop"); n != 4 {
+		t.Errorf("got %d snapshots, want 4", n)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.query != "/api/v2/write?bucket=mactop&org=lab&precision=ns" || stub.auth != "Token secret" {
+		t.Errorf("got request %s with %q", stub.query, stub.auth)
+	}
+	if !strings.Contains(stub.lines[0], " 1714600802000000000") {
+		t.Errorf("expected the third snapshot first, got %q", stub.lines[0])
+	}
+}
+
+func TestInfluxHTTPRejected(t *testing.T) {
+	var requests int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, `{"message":"bucket not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sink, err := NewInflux(InfluxOptions{Target: server.URL, FlushInterval: time.Hour}, m1Pro, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(powerSnapshot(0))
+	// Rejected batches are dropped instead of retried.
+	if err := sink.Close(); err != nil {
+		t.Errorf("got %v, want the rejected batch dropped", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
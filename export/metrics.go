@@ -0,0 +1,157 @@
+package export
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+// Cluster is the residency and frequency of a CPU cluster.
+type Cluster struct {
+	Name    string
+	Active  int
+	FreqMHz int
+}
+
+// Clusters lists the CPU clusters powermetrics reported. Chips reporting
+// numbered clusters, such as P0 and P1, get one entry per cluster; the
+// others get a single E and P entry.
+func Clusters(cpu *parser.CPUMetrics) []Cluster {
+	numbered := []Cluster{
+		{"E0", cpu.E0ClusterActive, cpu.E0ClusterFreqMHz},
+		{"E1", cpu.E1ClusterActive, cpu.E1ClusterFreqMHz},
+		{"P0", cpu.P0ClusterActive, cpu.P0ClusterFreqMHz},
+		{"P1", cpu.P1ClusterActive, cpu.P1ClusterFreqMHz},
+		{"P2", cpu.P2ClusterActive, cpu.P2ClusterFreqMHz},
+		{"P3", cpu.P3ClusterActive, cpu.P3ClusterFreqMHz},
+	}
+	combined := []Cluster{
+		{"E", cpu.EClusterActive, cpu.EClusterFreqMHz},
+		{"P", cpu.PClusterActive, cpu.PClusterFreqMHz},
+	}
+	var clusters []Cluster
+	for _, c := range combined {
+		found := false
+		for _, n := range numbered {
+			if strings.HasPrefix(n.Name, c.Name) && n.FreqMHz > 0 {
+				clusters = append(clusters, n)
+				found = true
+			}
+		}
+		if !found && (c.Active > 0 || c.FreqMHz > 0) {
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters
+}
+
+// Rail is the power drawn by a part of the chip.
+type Rail struct {
+	Name  string
+	Watts float64
+}
+
+// Rails lists the power of the CPU, GPU, ANE, DRAM and the whole package.
+// DRAM is left out where it is not measured.
+func Rails(s *collector.Snapshot) []Rail {
+	rails := []Rail{{"cpu", s.CPU.CPUW}, {"gpu", s.CPU.GPUW}, {"ane", s.CPU.ANEW}}
+	if s.CPU.DRAMW > 0 {
+		rails = append(rails, Rail{"dram", s.CPU.DRAMW})
+	}
+	return append(rails, Rail{"package", s.CPU.PackageW})
+}
+
+// totals adds up rates into cumulative totals. Every new sample of source
+// adds its rates times the time since the previous one, so snapshots
+// dropped on the way or sources sampled late do not lose anything.
+// Snapshots published again while a source is silent add nothing.
+type totals struct {
+	source collector.Source
+	last   time.Time
+	values map[string]float64
+}
+
+// maxGapIntervals is how many intervals apart two samples may be before
+// the rates are no longer taken to cover the time between them, as after
+// a source was stale or powermetrics restarted.
+const maxGapIntervals = 3
+
+func newTotals(source collector.Source) totals {
+	return totals{source: source, values: make(map[string]float64)}
+}
+
+func (t *totals) add(s *collector.Snapshot, rates map[string]float64) {
+	updated := s.Updated[t.source]
+	if updated.IsZero() || !updated.After(t.last) {
+		return
+	}
+	interval := time.Duration(s.Interval) * time.Millisecond
+	elapsed := updated.Sub(t.last)
+	// The first sample and one after a gap only cover their own
+	// interval.
+	if t.last.IsZero() || elapsed > maxGapIntervals*interval {
+		elapsed = interval
+	}
+	t.last = updated
+	for name, rate := range rates {
+		t.values[name] += rate * elapsed.Seconds()
+	}
+}
+
+// Energy adds up the energy drawn by every rail.
+type Energy struct {
+	totals
+}
+
+func NewEnergy() *Energy {
+	return &Energy{newTotals(collector.SourcePower)}
+}
+
+// Add counts the power of s unless it was counted before.
+func (e *Energy) Add(s *collector.Snapshot) {
+	watts := make(map[string]float64)
+	for _, rail := range Rails(s) {
+		watts[rail.Name] = rail.Watts
+	}
+	e.add(s, watts)
+}
+
+// Joules returns the energy drawn by rail so far.
+func (e *Energy) Joules(rail string) float64 {
+	return e.values[rail]
+}
+
+// StatusMetrics describe the collection rather than the machine. Sinks
+// report them next to collector.Metrics.
+var StatusMetrics = []collector.Metric{
+	{Name: "Stale", Unit: "bool", Help: "1 when a source has not been sampled for longer than expected", Value: func(s *collector.Snapshot) float64 {
+		return boolValue(s.Stale)
+	}},
+	{Name: "PowermetricsHealthy", Unit: "bool", Help: "0 while powermetrics is restarting or failed", Value: func(s *collector.Snapshot) float64 {
+		return boolValue(healthy(s.Health))
+	}},
+	{Name: "PowermetricsRestarts", Unit: "restarts", Help: "Restarts of powermetrics", Value: func(s *collector.Snapshot) float64 {
+		return float64(s.Health.Restarts)
+	}},
+}
+
+// healthy reports whether powermetrics is starting, running or not used at
+// all, as it is without root.
+func healthy(h collector.Health) bool {
+	return h.State != collector.StateRestarting && h.State != collector.StateFailed
+}
+
+// sinkMetrics lists collector.Metrics followed by StatusMetrics.
+func sinkMetrics() []collector.Metric {
+	return slices.Concat(collector.Metrics, StatusMetrics)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+)
+
+func TestEnergy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		seconds []float64
+		want    float64
+	}{
+		{"every sample", []float64{0, 1, 2}, 6},
+		{"dropped snapshots", []float64{0, 3}, 8},
+		{"late sample", []float64{0, 1.5}, 5},
+		{"published again", []float64{0, 1, 1}, 4},
+		{"gap after a stale period", []float64{0, 10}, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewEnergy()
+			for _, seconds := range tc.seconds {
+				s := powerSnapshot(0)
+				s.Updated[collector.SourcePower] = s.Time.Add(time.Duration(seconds * float64(time.Second)))
+				e.Add(s)
+			}
+			// The CPU draws 2 W.
+			if got := e.Joules("cpu"); got != tc.want {
+				t.Errorf("got %v J, want %v J", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/soc"
+	"github.com/sirupsen/logrus"
+)
+
+type OTLPOptions struct {
+	// Endpoint is the OTLP/HTTP URL, such as http://localhost:4318.
+	// URLs without a path get /v1/metrics. Empty turns it off.
+	Endpoint string
+	// Headers are sent with every request, for authentication.
+	Headers map[string]string
+	// Interval is how often the latest snapshot is exported.
+	Interval time.Duration
+}
+
+// otlp exports the latest snapshot as OTLP/JSON every interval. Counters
+// are cumulative, so an export that fails loses no energy or traffic; the
+// next one carries it.
+type otlp struct {
+	opts     OTLPOptions
+	endpoint string
+	client   *http.Client
+	resource []otlpKeyValue
+	version  string
+	start    time.Time
+
+	mu       sync.Mutex
+	snapshot *collector.Snapshot
+	socInfo  *soc.SocInfo
+	energy   *Energy
+	netDisk  totals
+	failing  bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewOTLP returns a sink exporting to opts.Endpoint. The resource
+// describes the host and SoC; version is the mactop version.
+func NewOTLP(opts OTLPOptions, socInfo *soc.SocInfo, host, version string) (Sink, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", opts.Endpoint)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/v1/metrics"
+	}
+	o := &otlp{
+		opts:     opts,
+		endpoint: endpoint.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		resource: otlpResourceAttributes(socInfo, host, version),
+		version:  version,
+		start:    time.Now(),
+		socInfo:  socInfo,
+		energy:   NewEnergy(),
+		netDisk:  newTotals(collector.SourceNetDisk),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+func otlpResourceAttributes(socInfo *soc.SocInfo, host, version string) []otlpKeyValue {
+	attrs := []otlpKeyValue{
+		stringAttr("service.name", "mactop"),
+		stringAttr("service.version", version),
+		stringAttr("host.name", host),
+		stringAttr("host.arch", runtime.GOARCH),
+		stringAttr("host.type", socInfo.ModelIdentifier),
+		stringAttr("host.cpu.model.name", socInfo.Name),
+		stringAttr("os.type", runtime.GOOS),
+		intAttr("mactop.soc.e_cores", socInfo.ECoreCount),
+		intAttr("mactop.soc.p_cores", socInfo.PCoreCount),
+		intAttr("mactop.soc.gpu_cores", socInfo.GpuCoreCount),
+		intAttr("mactop.soc.memory_bytes", int(socInfo.MemoryBytes)),
+	}
+	// Unknown values are left out rather than sent empty.
+	known := attrs[:0]
+	for _, a := range attrs {
+		if a.Value.StringValue != "" || (a.Value.IntValue != "" && a.Value.IntValue != "0") {
+			known = append(known, a)
+		}
+	}
+	return known
+}
+
+func (o *otlp) Write(s *collector.Snapshot) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.snapshot = s
+	o.energy.Add(s)
+	n := s.NetDisk
+	o.netDisk.add(s, map[string]float64{
+		"network_bytes_receive":    n.InBytesPerSec,
+		"network_bytes_transmit":   n.OutBytesPerSec,
+		"network_packets_receive":  n.InPacketsPerSec,
+		"network_packets_transmit": n.OutPacketsPerSec,
+		"disk_bytes_read":          n.ReadKBytesPerSec * 1024,
+		"disk_bytes_write":         n.WriteKBytesPerSec * 1024,
+		"disk_operations_read":     n.ReadOpsPerSec,
+		"disk_operations_write":    n.WriteOpsPerSec,
+	})
+	return nil
+}
+
+// Close exports the latest snapshot one last time.
+func (o *otlp) Close() error {
+	close(o.done)
+	<-o.stopped
+	return nil
+}
+
+func (o *otlp) run() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(o.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.export()
+		case <-o.done:
+			o.export()
+			return
+		}
+	}
+}
+
+// export sends the latest snapshot. Only the first of a run of failures is
+// logged.
+func (o *otlp) export() {
+	o.mu.Lock()
+	if o.snapshot == nil {
+		o.mu.Unlock()
+		return
+	}
+	body, err := json.Marshal(o.request())
+	o.mu.Unlock()
+	if err == nil {
+		err = o.post(body)
+	}
+	if err != nil {
+		if !o.failing {
+			logrus.Errorf("otlp: %v", err)
+		}
+		o.failing = true
+		return
+	}
+	o.failing = false
+}
+
+func (o *otlp) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// request maps the latest snapshot to OTel metrics, following the
+// semantic conventions for system and hardware metrics where they exist.
+// Metrics without a convention are named mactop.*.
+func (o *otlp) request() otlpRequest {
+	s := o.snapshot
+	b := &otlpBuilder{
+		time:  strconv.FormatInt(s.Time.UnixNano(), 10),
+		start: strconv.FormatInt(o.start.UnixNano(), 10),
+	}
+
+	b.gauge("mactop.stale", "1", "1 when a source has not been sampled for longer than expected", b.point(boolValue(s.Stale)))
+	b.gauge("mactop.powermetrics.healthy", "1", "0 while powermetrics is restarting or failed", b.point(boolValue(healthy(s.Health))))
+	b.sum("mactop.powermetrics.restarts", "{restart}", "Restarts of powermetrics", true, b.point(float64(s.Health.Restarts)))
+
+	if !s.Updated[collector.SourcePower].IsZero() {
+		var active, freq []otlpDataPoint
+		for _, c := range Clusters(&s.CPU) {
+			active = append(active, b.point(float64(c.Active)/100, "mactop.cpu.cluster", c.Name))
+			freq = append(freq, b.point(float64(c.FreqMHz)*1e6, "mactop.cpu.cluster", c.Name))
+		}
+		b.gauge("mactop.cpu.cluster.utilization", "1", "Active residency of a CPU cluster", active...)
+		b.gauge("mactop.cpu.cluster.frequency", "Hz", "Frequency of a CPU cluster", freq...)
+
+		var power, energy []otlpDataPoint
+		for _, rail := range Rails(s) {
+			power = append(power, b.point(rail.Watts, "hw.id", rail.Name))
+			energy = append(energy, b.point(o.energy.Joules(rail.Name), "hw.id", rail.Name))
+		}
+		b.gauge("hw.power", "W", "Power drawn by a rail", power...)
+		b.sum("hw.energy", "J", "Energy drawn by a rail since mactop started", true, energy...)
+
+		b.gauge("hw.gpu.utilization", "1", "GPU active residency", b.point(s.GPU.Active/100, "hw.id", "gpu"))
+		b.gauge("mactop.gpu.frequency", "Hz", "GPU frequency", b.point(float64(s.GPU.FreqMHz)*1e6, "hw.id", "gpu"))
+		if o.socInfo.AneMaxPower > 0 {
+			b.gauge("mactop.ane.utilization", "1", "ANE power relative to its power at full load", b.point(s.CPU.ANEW/o.socInfo.AneMaxPower))
+		}
+		if s.ThermalPressure != "" {
+			level := float64(slices.Index(parser.ThermalPressureLevels, s.ThermalPressure))
+			b.gauge("mactop.thermal.pressure", "{level}", "Thermal pressure from 0 (nominal) to 4 (sleeping)",
+				b.point(level, "mactop.thermal.pressure.level", strings.ToLower(s.ThermalPressure)))
+		}
+	}
+
+	var usage, coreFreq []otlpDataPoint
+	for core, v := range s.CoreUsage {
+		usage = append(usage, b.intPoint(v/100, "cpu.logical_number", core))
+	}
+	for core, mhz := range s.CoreFreqMHz {
+		coreFreq = append(coreFreq, b.intPoint(float64(mhz)*1e6, "cpu.logical_number", core))
+	}
+	b.gauge("system.cpu.utilization", "1", "Usage of a logical core", usage...)
+	b.gauge("system.cpu.frequency", "Hz", "Frequency of a logical core", coreFreq...)
+
+	var temperatures []otlpDataPoint
+	for _, sensor := range sortedKeys(s.Temperatures) {
+		temperatures = append(temperatures, b.point(s.Temperatures[sensor], "hw.id", sensor))
+	}
+	b.gauge("hw.temperature", "Cel", "Temperature of a hardware sensor", temperatures...)
+
+	if m := s.Memory; !s.Updated[collector.SourceMemory].IsZero() {
+		b.sum("system.memory.usage", "By", "Used memory", false, b.point(float64(m.Used), "system.memory.state", "used"))
+		b.sum("system.memory.limit", "By", "Physical memory", false, b.point(float64(m.Total)))
+		if m.Total > 0 {
+			b.gauge("system.memory.utilization", "1", "Used memory relative to physical memory", b.point(float64(m.Used)/float64(m.Total), "system.memory.state", "used"))
+		}
+		b.sum("system.paging.usage", "By", "Swap usage", false,
+			b.point(float64(m.SwapUsed), "system.paging.state", "used"),
+			b.point(float64(m.SwapTotal-min(m.SwapUsed, m.SwapTotal)), "system.paging.state", "free"))
+	}
+
+	if !s.Updated[collector.SourceNetDisk].IsZero() {
+		t := o.netDisk.values
+		b.sum("system.network.io", "By", "Network traffic since mactop started", true,
+			b.point(t["network_bytes_receive"], "network.io.direction", "receive"),
+			b.point(t["network_bytes_transmit"], "network.io.direction", "transmit"))
+		b.sum("system.network.packets", "{packet}", "Network packets since mactop started", true,
+			b.point(t["network_packets_receive"], "network.io.direction", "receive"),
+			b.point(t["network_packets_transmit"], "network.io.direction", "transmit"))
+		b.sum("system.disk.io", "By", "Disk traffic since mactop started", true,
+			b.point(t["disk_bytes_read"], "disk.io.direction", "read"),
+			b.point(t["disk_bytes_write"], "disk.io.direction", "write"))
+		b.sum("system.disk.operations", "{operation}", "Disk operations since mactop started", true,
+			b.point(t["disk_operations_read"], "disk.io.direction", "read"),
+			b.point(t["disk_operations_write"], "disk.io.direction", "write"))
+	}
+
+	if d := s.DiskSpace; !s.Updated[collector.SourceDiskSpace].IsZero() {
+		b.sum("system.filesystem.usage", "By", "Filesystem usage", false,
+			b.point(float64(d.Used), "system.filesystem.state", "used", "system.filesystem.mountpoint", d.Path),
+			b.point(float64(d.Free), "system.filesystem.state", "free", "system.filesystem.mountpoint", d.Path))
+	}
+
+	for _, name := range sortedKeys(s.Derived) {
+		b.gauge("mactop.derived."+name, "", "Derived metric of the config file", b.point(s.Derived[name]))
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: o.resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "mactop", Version: o.version},
+			Metrics: b.metrics,
+		}},
+	}}}
+}
+
+// otlpBuilder collects the metrics of one export.
+type otlpBuilder struct {
+	time    string
+	start   string
+	metrics []otlpMetric
+}
+
+// point is a data point with string attributes given as key, value pairs.
+func (b *otlpBuilder) point(v float64, attrs ...string) otlpDataPoint {
+	p := otlpDataPoint{TimeUnixNano: b.time, AsDouble: v}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		p.Attributes = append(p.Attributes, stringAttr(attrs[i], attrs[i+1]))
+	}
+	return p
+}
+
+func (b *otlpBuilder) intPoint(v float64, key string, value int) otlpDataPoint {
+	p := otlpDataPoint{TimeUnixNano: b.time, AsDouble: v}
+	p.Attributes = append(p.Attributes, intAttr(key, value))
+	return p
+}
+
+// gauge adds a gauge unless it has no data points.
+func (b *otlpBuilder) gauge(name, unit, description string, points ...otlpDataPoint) {
+	if len(points) == 0 {
+		return
+	}
+	b.metrics = append(b.metrics, otlpMetric{Name: name, Unit: unit, Description: description, Gauge: &otlpGauge{DataPoints: points}})
+}
+
+// sum adds a cumulative sum counting from when mactop started.
+func (b *otlpBuilder) sum(name, unit, description string, monotonic bool, points ...otlpDataPoint) {
+	if len(points) == 0 {
+		return
+	}
+	for i := range points {
+		points[i].StartTimeUnixNano = b.start
+	}
+	b.metrics = append(b.metrics, otlpMetric{Name: name, Unit: unit, Description: description, Sum: &otlpSum{
+		DataPoints:             points,
+		AggregationTemporality: otlpCumulative,
+		IsMonotonic:            monotonic,
+	}})
+}
+
+// The types below are the JSON encoding of an OTLP
+// ExportMetricsServiceRequest. 64-bit integers are strings, as protobuf
+// JSON requires.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+func intAttr(key string, value int) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: strconv.Itoa(value)}}
+}
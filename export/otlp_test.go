@@ -0,0 +1,172 @@
+package export
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+)
+
+// otlpReceiver decodes every export and fails the first ones.
+type otlpReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []otlpRequest
+	header   http.Header
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path != "/v1/metrics" || req.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var request otlpRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, request)
+	r.header = req.Header
+	w.Write([]byte("{}"))
+}
+
+func findMetric(metrics []otlpMetric, name string) *otlpMetric {
+	for i := range metrics {
+		if metrics[i].Name == name {
+			return &metrics[i]
+		}
+	}
+	return nil
+}
+
+func TestOTLP(t *testing.T) {
+	receiver := &otlpReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	sink, err := NewOTLP(OTLPOptions{
+		Endpoint: server.URL,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Interval: 20 * time.Millisecond,
+	}, m1Pro, "build-01", "v2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		sink.Write(powerSnapshot(i))
+	}
+	// The first export fails; the next carries the same totals.
+	waitFor(t, "an export", func() bool {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		return len(receiver.requests) > 0
+	})
+	s := powerSnapshot(2)
+	s.Memory.Total, s.Memory.Used = 32<<30, 8<<30
+	s.Updated[collector.SourceMemory] = s.Time
+	s.Stale = true
+	s.Health = collector.Health{State: collector.StateFailed, Restarts: 5, LastError: "powermetrics exited"}
+	sink.Write(s)
+	sink.Close()
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if got := receiver.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got authorization %q", got)
+	}
+	last := receiver.requests[len(receiver.requests)-1].ResourceMetrics[0]
+	attrs := make(map[string]otlpAnyValue)
+	for _, a := range last.Resource.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["host.name"].StringValue != "build-01" || attrs["host.cpu.model.name"].StringValue != "Apple M1 Pro" ||
+		attrs["service.version"].StringValue != "v2.0.0" || attrs["mactop.soc.p_cores"].IntValue != "8" {
+		t.Errorf("unexpected resource %+v", last.Resource.Attributes)
+	}
+	if _, ok := attrs["host.type"]; ok {
+		t.Error("expected no host.type for an unknown model")
+	}
+
+	metrics := last.ScopeMetrics[0].Metrics
+	energy := findMetric(metrics, "hw.energy")
+	if energy == nil || energy.Sum == nil || !energy.Sum.IsMonotonic || energy.Sum.AggregationTemporality != otlpCumulative {
+		t.Fatalf("unexpected hw.energy %+v", energy)
+	}
+	cpu := energy.Sum.DataPoints[0]
+	if cpu.Attributes[0].Value.StringValue != "cpu" || cpu.AsDouble != 6 || cpu.StartTimeUnixNano == "" {
+		t.Errorf("got CPU energy %+v, want 6 J", cpu)
+	}
+
+	usage := findMetric(metrics, "system.cpu.utilization")
+	if usage == nil || usage.Gauge == nil || len(usage.Gauge.DataPoints) != 3 {
+		t.Fatalf("unexpected system.cpu.utilization %+v", usage)
+	}
+	if p := usage.Gauge.DataPoints[1]; p.AsDouble != 0.25 || p.Attributes[0].Key != "cpu.logical_number" || p.Attributes[0].Value.IntValue != "1" {
+		t.Errorf("unexpected core usage %+v", p)
+	}
+	memory := findMetric(metrics, "system.memory.usage")
+	if memory == nil || memory.Sum == nil || memory.Sum.IsMonotonic || memory.Sum.DataPoints[0].AsDouble != 8<<30 {
+		t.Errorf("unexpected system.memory.usage %+v", memory)
+	}
+	if stale := findMetric(metrics, "mactop.stale"); stale == nil || stale.Gauge.DataPoints[0].AsDouble != 1 {
+		t.Errorf("unexpected mactop.stale %+v", stale)
+	}
+	if healthy := findMetric(metrics, "mactop.powermetrics.healthy"); healthy == nil || healthy.Gauge.DataPoints[0].AsDouble != 0 {
+		t.Errorf("unexpected mactop.powermetrics.healthy %+v", healthy)
+	}
+	if restarts := findMetric(metrics, "mactop.powermetrics.restarts"); restarts == nil || restarts.Sum == nil || restarts.Sum.DataPoints[0].AsDouble != 5 {
+		t.Errorf("unexpected mactop.powermetrics.restarts %+v", restarts)
+	}
+	if findMetric(metrics, "mactop.thermal.pressure") == nil || findMetric(metrics, "system.network.io") != nil {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestOTLPClusterUtilization(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	sink, err := NewOTLP(OTLPOptions{Endpoint: server.URL, Interval: time.Hour}, m1Pro, "build-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Under sudo, powermetrics reports clusters but no per-core usage.
+	s := powerSnapshot(0)
+	s.CoreUsage = nil
+	sink.Write(s)
+	sink.Close()
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	metrics := receiver.requests[0].ResourceMetrics[0].ScopeMetrics[0].Metrics
+	// Clusters keep their own metric, so system.cpu.utilization only ever
+	// has per-core points.
+	if usage := findMetric(metrics, "system.cpu.utilization"); usage != nil {
+		t.Errorf("expected no system.cpu.utilization without core usage, got %+v", usage)
+	}
+	clusters := findMetric(metrics, "mactop.cpu.cluster.utilization")
+	if clusters == nil || clusters.Gauge == nil || len(clusters.Gauge.DataPoints) != 3 {
+		t.Fatalf("unexpected mactop.cpu.cluster.utilization %+v", clusters)
+	}
+	if p := clusters.Gauge.DataPoints[1]; p.AsDouble != 0.12 || p.Attributes[0].Value.StringValue != "P0" {
+		t.Errorf("unexpected cluster usage %+v", p)
+	}
+}
+
+func TestOTLPInvalidEndpoint(t *testing.T) {
+	if _, err := NewOTLP(OTLPOptions{Endpoint: "localhost:4318"}, m1Pro, "host", ""); err == nil {
+		t.Error("expected an error for an endpoint without a scheme")
+	}
+}
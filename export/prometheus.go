@@ -0,0 +1,296 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/soc"
+	"github.com/sirupsen/logrus"
+)
+
+type PrometheusOptions struct {
+	// Listen is the address serving /metrics, such as ":9100". Empty
+	// turns the exporter off.
+	Listen string
+	// Processes exposes the CPU time of the top this many processes.
+	// Zero exposes none.
+	Processes int
+}
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Prometheus serves the latest snapshot at /metrics in the Prometheus text
+// format, or in OpenMetrics when the scraper asks for it.
+type Prometheus struct {
+	opts     PrometheusOptions
+	socInfo  *soc.SocInfo
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	snapshot *collector.Snapshot
+	energy   *Energy
+}
+
+// NewPrometheus starts serving on opts.Listen.
+func NewPrometheus(opts PrometheusOptions, socInfo *soc.SocInfo) (*Prometheus, error) {
+	listener, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", opts.Listen, err)
+	}
+	p := &Prometheus{opts: opts, socInfo: socInfo, listener: listener, energy: NewEnergy()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", p.serveMetrics)
+	p.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("prometheus exporter: %v", err)
+		}
+	}()
+	return p, nil
+}
+
+// Addr returns the address the exporter listens on.
+func (p *Prometheus) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+func (p *Prometheus) Write(s *collector.Snapshot) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.energy.Add(s)
+	p.snapshot = s
+	return nil
+}
+
+func (p *Prometheus) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func (p *Prometheus) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var buf bytes.Buffer
+	p.mu.Lock()
+	p.writeMetrics(&buf, openMetrics)
+	p.mu.Unlock()
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+	w.Write(buf.Bytes())
+}
+
+func (p *Prometheus) writeMetrics(w io.Writer, openMetrics bool) {
+	e := &expositionWriter{w: w, openMetrics: openMetrics, chip: p.socInfo.Name}
+	s := p.snapshot
+
+	info := e.family("mactop_soc_info", "gauge", "Chip of the machine, with the core counts as labels")
+	info.sample(1, "e_cores", strconv.Itoa(p.socInfo.ECoreCount), "p_cores", strconv.Itoa(p.socInfo.PCoreCount), "gpu_cores", strconv.Itoa(p.socInfo.GpuCoreCount))
+	if s == nil {
+		e.end()
+		return
+	}
+
+	e.family("mactop_stale", "gauge", "1 when a source has not been sampled for longer than expected").sample(boolValue(s.Stale))
+	e.family("mactop_powermetrics_healthy", "gauge", "0 while powermetrics is restarting or failed").sample(boolValue(healthy(s.Health)))
+	e.family("mactop_powermetrics_restarts_total", "counter", "Restarts of powermetrics").sample(float64(s.Health.Restarts))
+
+	// Power is never sampled without root.
+	if !s.Updated[collector.SourcePower].IsZero() {
+		active := e.family("mactop_cpu_cluster_active_ratio", "gauge", "Active residency of a CPU cluster")
+		freq := e.family("mactop_cpu_cluster_frequency_hertz", "gauge", "Frequency of a CPU cluster")
+		for _, c := range Clusters(&s.CPU) {
+			active.sample(float64(c.Active)/100, "cluster", c.Name)
+			freq.sample(float64(c.FreqMHz)*1e6, "cluster", c.Name)
+		}
+
+		power := e.family("mactop_power_watts", "gauge", "Power drawn by a rail")
+		for _, rail := range Rails(s) {
+			power.sample(rail.Watts, "rail", rail.Name)
+		}
+		energy := e.family("mactop_energy_joules_total", "counter", "Energy drawn by a rail since mactop started")
+		for _, rail := range Rails(s) {
+			energy.sample(p.energy.Joules(rail.Name), "rail", rail.Name)
+		}
+
+		e.family("mactop_gpu_active_ratio", "gauge", "GPU active residency").sample(s.GPU.Active / 100)
+		e.family("mactop_gpu_frequency_hertz", "gauge", "GPU frequency").sample(float64(s.GPU.FreqMHz) * 1e6)
+		if p.socInfo.AneMaxPower > 0 {
+			e.family("mactop_ane_utilization_ratio", "gauge", "ANE power relative to its power at full load").sample(s.CPU.ANEW / p.socInfo.AneMaxPower)
+		}
+
+		if s.ThermalPressure != "" {
+			thermal := e.family("mactop_thermal_pressure", "gauge", "1 for the current thermal pressure level")
+			for _, level := range parser.ThermalPressureLevels {
+				value := 0.0
+				if level == s.ThermalPressure {
+					value = 1
+				}
+				thermal.sample(value, "level", strings.ToLower(level))
+			}
+		}
+	}
+
+	if len(s.CoreUsage) > 0 || len(s.CoreFreqMHz) > 0 {
+		usage := e.family("mactop_cpu_core_usage_ratio", "gauge", "Usage of a logical core")
+		for core, v := range s.CoreUsage {
+			usage.sample(v/100, coreLabels(&s.CPU, core)...)
+		}
+		freq := e.family("mactop_cpu_core_frequency_hertz", "gauge", "Frequency of a logical core")
+		for core, mhz := range s.CoreFreqMHz {
+			freq.sample(float64(mhz)*1e6, coreLabels(&s.CPU, core)...)
+		}
+	}
+
+	if len(s.Temperatures) > 0 {
+		temperature := e.family("mactop_temperature_celsius", "gauge", "Temperature of a hardware sensor")
+		for _, sensor := range sortedKeys(s.Temperatures) {
+			temperature.sample(s.Temperatures[sensor], "sensor", sensor)
+		}
+	}
+
+	if !s.Updated[collector.SourceMemory].IsZero() {
+		e.family("mactop_memory_total_bytes", "gauge", "Physical memory").sample(float64(s.Memory.Total))
+		e.family("mactop_memory_used_bytes", "gauge", "Used memory").sample(float64(s.Memory.Used))
+		e.family("mactop_memory_available_bytes", "gauge", "Available memory").sample(float64(s.Memory.Available))
+		e.family("mactop_swap_total_bytes", "gauge", "Swap size").sample(float64(s.Memory.SwapTotal))
+		e.family("mactop_swap_used_bytes", "gauge", "Used swap").sample(float64(s.Memory.SwapUsed))
+	}
+
+	if !s.Updated[collector.SourceNetDisk].IsZero() {
+		n := s.NetDisk
+		netBytes := e.family("mactop_network_bytes_per_second", "gauge", "Network throughput")
+		netBytes.sample(n.InBytesPerSec, "direction", "receive")
+		netBytes.sample(n.OutBytesPerSec, "direction", "transmit")
+		netPackets := e.family("mactop_network_packets_per_second", "gauge", "Network packet rate")
+		netPackets.sample(n.InPacketsPerSec, "direction", "receive")
+		netPackets.sample(n.OutPacketsPerSec, "direction", "transmit")
+		diskBytes := e.family("mactop_disk_bytes_per_second", "gauge", "Disk throughput")
+		diskBytes.sample(n.ReadKBytesPerSec*1024, "direction", "read")
+		diskBytes.sample(n.WriteKBytesPerSec*1024, "direction", "write")
+		diskOps := e.family("mactop_disk_operations_per_second", "gauge", "Disk operation rate")
+		diskOps.sample(n.ReadOpsPerSec, "direction", "read")
+		diskOps.sample(n.WriteOpsPerSec, "direction", "write")
+	}
+
+	if !s.Updated[collector.SourceDiskSpace].IsZero() {
+		d := s.DiskSpace
+		e.family("mactop_filesystem_size_bytes", "gauge", "Size of the filesystem").sample(float64(d.Total), "path", d.Path)
+		e.family("mactop_filesystem_used_bytes", "gauge", "Used space of the filesystem").sample(float64(d.Used), "path", d.Path)
+		e.family("mactop_filesystem_free_bytes", "gauge", "Free space of the filesystem").sample(float64(d.Free), "path", d.Path)
+	}
+
+	if len(s.Derived) > 0 {
+		derived := e.family("mactop_derived", "gauge", "Derived metrics of the config file")
+		for _, name := range sortedKeys(s.Derived) {
+			derived.sample(s.Derived[name], "name", name)
+		}
+	}
+
+	if p.opts.Processes > 0 && len(s.Processes) > 0 {
+		processes := e.family("mactop_process_cpu_milliseconds_per_second", "gauge", "CPU time of the busiest processes")
+		// Only powermetrics sorts processes by CPU time, and the slice is
+		// shared with other subscribers, so sort a copy.
+		busiest := slices.Clone(s.Processes)
+		sort.Slice(busiest, func(i, j int) bool {
+			return busiest[i].CPUUsage > busiest[j].CPUUsage
+		})
+		for _, proc := range busiest[:min(p.opts.Processes, len(busiest))] {
+			processes.sample(proc.CPUUsage, "pid", strconv.Itoa(proc.ID), "name", proc.Name)
+		}
+	}
+	e.end()
+}
+
+// coreLabels labels a core with its cluster when powermetrics reported
+// it.
+func coreLabels(cpu *parser.CPUMetrics, core int) []string {
+	labels := []string{"core", strconv.Itoa(core)}
+	switch {
+	case slices.Contains(cpu.ECores, core):
+		labels = append(labels, "cluster", "E")
+	case slices.Contains(cpu.PCores, core):
+		labels = append(labels, "cluster", "P")
+	}
+	return labels
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expositionWriter writes metric families in the Prometheus text format
+// or in OpenMetrics. Every sample is labelled with the chip.
+type expositionWriter struct {
+	w           io.Writer
+	openMetrics bool
+	chip        string
+}
+
+type metricFamily struct {
+	e    *expositionWriter
+	name string
+}
+
+func (e *expositionWriter) family(name, kind, help string) metricFamily {
+	header := name
+	if e.openMetrics && kind == "counter" {
+		// OpenMetrics names the family of a counter without the _total
+		// suffix of its samples.
+		header = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", header, help, header, kind)
+	return metricFamily{e: e, name: name}
+}
+
+// sample writes a value with labels given as name, value pairs.
+func (f metricFamily) sample(value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(f.name)
+	b.WriteString(`{chip="`)
+	b.WriteString(escapeLabel(f.e.chip))
+	b.WriteByte('"')
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(&b, `,%s="%s"`, labels[i], escapeLabel(labels[i+1]))
+	}
+	b.WriteString("} ")
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	io.WriteString(f.e.w, b.String())
+}
+
+func (e *expositionWriter) end() {
+	if e.openMetrics {
+		io.WriteString(e.w, "# EOF\n")
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,125 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/parser"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+var m1Pro = &soc.SocInfo{Name: "Apple M1 Pro", ECoreCount: 2, PCoreCount: 8, GpuCoreCount: 16, AneMaxPower: 8}
+
+func powerSnapshot(seconds int) *collector.Snapshot {
+	t := start.Add(time.Duration(seconds) * time.Second)
+	return &collector.Snapshot{
+		Time:     t,
+		Interval: 1000,
+		CPU: parser.CPUMetrics{
+			EClusterActive: 30, EClusterFreqMHz: 1035,
+			P0ClusterActive: 12, P0ClusterFreqMHz: 1241,
+			P1ClusterActive: 8, P1ClusterFreqMHz: 1102,
+			ECores: []int{0, 1},
+			CPUW:   2, GPUW: 1, ANEW: 0.5, PackageW: 3.5,
+		},
+		CoreUsage:       []float64{50, 25, 10},
+		GPU:             parser.GPUMetrics{Active: 2.5, FreqMHz: 389},
+		ThermalPressure: "Moderate",
+		// In PID order, as the unprivileged and Linux backends return them.
+		Processes: []parser.ProcessMetrics{
+			{ID: 401, Name: "Safari", CPUUsage: 12.5},
+			{ID: 1204, Name: `Window"Server`, CPUUsage: 29.14},
+		},
+		Updated: map[collector.Source]time.Time{collector.SourcePower: t},
+	}
+}
+
+func scrape(t *testing.T, p *Prometheus, accept string) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "http://"+p.Addr().String()+"/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), resp.Header.Get("Content-Type")
+}
+
+func TestPrometheus(t *testing.T) {
+	p, err := NewPrometheus(PrometheusOptions{Listen: "127.0.0.1:0", Processes: 1}, m1Pro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	body, _ := scrape(t, p, "")
+	if !strings.Contains(body, `mactop_soc_info{chip="Apple M1 Pro",e_cores="2",p_cores="8",gpu_cores="16"} 1`) || strings.Contains(body, "mactop_power_watts") {
+		t.Errorf("unexpected metrics before the first snapshot:\n%s", body)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.Write(powerSnapshot(i))
+	}
+	// Published again while powermetrics is silent.
+	stale := powerSnapshot(2)
+	stale.Time = stale.Time.Add(5 * time.Second)
+	stale.Stale = true
+	stale.Health = collector.Health{State: collector.StateRestarting, Restarts: 2}
+	p.Write(stale)
+
+	body, contentType := scrape(t, p, "")
+	if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", contentType)
+	}
+	for _, want := range []string{
+		"# TYPE mactop_power_watts gauge\n",
+		`mactop_power_watts{chip="Apple M1 Pro",rail="package"} 3.5`,
+		"# TYPE mactop_energy_joules_total counter\n",
+		`mactop_energy_joules_total{chip="Apple M1 Pro",rail="cpu"} 6`,
+		`mactop_cpu_cluster_active_ratio{chip="Apple M1 Pro",cluster="E"} 0.3`,
+		`mactop_cpu_cluster_frequency_hertz{chip="Apple M1 Pro",cluster="P1"} 1.102e+09`,
+		`mactop_cpu_core_usage_ratio{chip="Apple M1 Pro",core="1",cluster="E"} 0.25`,
+		`mactop_cpu_core_usage_ratio{chip="Apple M1 Pro",core="2"} 0.1`,
+		`mactop_ane_utilization_ratio{chip="Apple M1 Pro"} 0.0625`,
+		`mactop_thermal_pressure{chip="Apple M1 Pro",level="moderate"} 1`,
+		`mactop_thermal_pressure{chip="Apple M1 Pro",level="nominal"} 0`,
+		`mactop_stale{chip="Apple M1 Pro"} 1`,
+		`mactop_powermetrics_healthy{chip="Apple M1 Pro"} 0`,
+		`mactop_powermetrics_restarts_total{chip="Apple M1 Pro"} 2`,
+		`mactop_process_cpu_milliseconds_per_second{chip="Apple M1 Pro",pid="1204",name="Window\"Server"} 29.14`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Safari") || strings.Contains(body, `cluster="P"}`) || strings.Contains(body, "mactop_memory") {
+		t.Errorf("unexpected metrics in:\n%s", body)
+	}
+
+	body, contentType = scrape(t, p, "application/openmetrics-text; version=1.0.0")
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") || !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("got %q, want OpenMetrics", contentType)
+	}
+	if !strings.Contains(body, "# TYPE mactop_energy_joules counter\n") {
+		t.Errorf("counter family should drop _total in OpenMetrics:\n%s", body)
+	}
+}
+
+func TestPrometheusListenError(t *testing.T) {
+	p, err := NewPrometheus(PrometheusOptions{Listen: "127.0.0.1:0"}, m1Pro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, err := Open(Options{Prometheus: PrometheusOptions{Listen: p.Addr().String()}}, m1Pro); err == nil {
+		t.Error("expected an error for an address in use")
+	}
+}
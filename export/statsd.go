@@ -0,0 +1,148 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+type StatsDOptions struct {
+	// Addr is the host:port of the StatsD agent, usually
+	// localhost:8125. Empty turns it off.
+	Addr string
+	// Prefix is put in front of every metric name, e.g. "mactop.".
+	Prefix string
+	// Tags sends DogStatsD tags. Plain StatsD has none, so the cluster and
+	// core go into the metric name instead.
+	Tags bool
+}
+
+// maxStatsDPacket keeps datagrams below the usual MTU.
+const maxStatsDPacket = 1432
+
+type statsD struct {
+	opts StatsDOptions
+	conn net.Conn
+	// tags are the DogStatsD tags of every metric.
+	tags []string
+}
+
+// NewStatsD returns a sink sending a gauge for every metric over UDP. With
+// tags, every gauge is tagged with the chip and host.
+func NewStatsD(opts StatsDOptions, socInfo *soc.SocInfo, host string) (Sink, error) {
+	conn, err := net.Dial("udp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach StatsD at %s: %w", opts.Addr, err)
+	}
+	return &statsD{
+		opts: opts,
+		conn: conn,
+		tags: []string{"chip:" + statsDTag(socInfo.Name), "host:" + statsDTag(host)},
+	}, nil
+}
+
+var statsDTagEscaper = strings.NewReplacer(" ", "_", ",", "_", "|", "_", "#", "_", "\n", "_")
+
+func statsDTag(value string) string {
+	return statsDTagEscaper.Replace(value)
+}
+
+// statsDNameEscaper removes what would end a name early.
+var statsDNameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", " ", "_", "\n", "_")
+
+func (d *statsD) Write(s *collector.Snapshot) error {
+	var packets packetWriter
+	gauge := func(name string, v float64, tags ...string) {
+		var b strings.Builder
+		b.WriteString(statsDNameEscaper.Replace(d.opts.Prefix + name))
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		b.WriteString("|g")
+		if d.opts.Tags {
+			b.WriteString("|#")
+			b.WriteString(strings.Join(append(d.tags[:len(d.tags):len(d.tags)], tags...), ","))
+		}
+		packets.add(b.String())
+	}
+	// labelled sends a gauge of a cluster or core, as a tag or as part of
+	// the name. Extra tags are only sent as tags.
+	labelled := func(kind, label, name string, v float64, extra ...string) {
+		if d.opts.Tags {
+			gauge(kind+"."+name, v, append([]string{kind + ":" + statsDTag(label)}, extra...)...)
+		} else {
+			gauge(kind+"."+label+"."+name, v)
+		}
+	}
+
+	for _, m := range sinkMetrics() {
+		gauge(m.Name, m.Value(s))
+	}
+	for _, name := range sortedKeys(s.Derived) {
+		gauge(name, s.Derived[name])
+	}
+	for _, c := range Clusters(&s.CPU) {
+		labelled("cluster", c.Name, "active", float64(c.Active))
+		labelled("cluster", c.Name, "freq_mhz", float64(c.FreqMHz))
+	}
+	coreTags := func(core int) []string {
+		if labels := coreLabels(&s.CPU, core); len(labels) == 4 {
+			return []string{"cluster:" + labels[3]}
+		}
+		return nil
+	}
+	for core, usage := range s.CoreUsage {
+		labelled("core", strconv.Itoa(core), "usage", usage, coreTags(core)...)
+	}
+	for core, mhz := range s.CoreFreqMHz {
+		labelled("core", strconv.Itoa(core), "freq_mhz", float64(mhz), coreTags(core)...)
+	}
+
+	var errs []error
+	for _, packet := range packets.done() {
+		if _, err := d.conn.Write(packet); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send to StatsD: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (d *statsD) Close() error {
+	return d.conn.Close()
+}
+
+// packetWriter joins lines into datagrams of up to maxStatsDPacket bytes.
+type packetWriter struct {
+	packets [][]byte
+	current bytes.Buffer
+}
+
+func (p *packetWriter) add(line string) {
+	if p.current.Len() > 0 && p.current.Len()+1+len(line) > maxStatsDPacket {
+		p.flush()
+	}
+	if p.current.Len() > 0 {
+		p.current.WriteByte('\n')
+	}
+	p.current.WriteString(line)
+}
+
+func (p *packetWriter) flush() {
+	p.packets = append(p.packets, bytes.Clone(p.current.Bytes()))
+	p.current.Reset()
+}
+
+func (p *packetWriter) done() [][]byte {
+	if p.current.Len() > 0 {
+		p.flush()
+	}
+	return p.packets
+}
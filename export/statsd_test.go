@@ -0,0 +1,76 @@
+package export
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receiveStatsD sends a snapshot through a sink and returns the lines that
+// arrived.
+func receiveStatsD(t *testing.T, opts StatsDOptions) []string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	opts.Addr = conn.LocalAddr().String()
+	sink, err := NewStatsD(opts, m1Pro, "build-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(powerSnapshot(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > maxStatsDPacket {
+			t.Errorf("got a %d byte packet", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	return lines
+}
+
+func TestStatsD(t *testing.T) {
+	lines := receiveStatsD(t, StatsDOptions{Prefix: "mactop."})
+	for _, want := range []string{
+		"mactop.CPUW:2|g",
+		"mactop.ThermalPressure:1|g",
+		"mactop.Stale:0|g",
+		"mactop.PowermetricsHealthy:1|g",
+		"mactop.PowermetricsRestarts:0|g",
+		"mactop.cluster.P0.freq_mhz:1241|g",
+		"mactop.core.1.usage:25|g",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+}
+
+func TestDogStatsD(t *testing.T) {
+	lines := receiveStatsD(t, StatsDOptions{Tags: true})
+	for _, want := range []string{
+		"PackageW:3.5|g|#chip:Apple_M1_Pro,host:build-01",
+		"cluster.active:30|g|#chip:Apple_M1_Pro,host:build-01,cluster:E",
+		"core.usage:10|g|#chip:Apple_M1_Pro,host:build-01,core:2",
+		"core.usage:50|g|#chip:Apple_M1_Pro,host:build-01,core:0,cluster:E",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Package expr evaluates the small arithmetic language used to define
+// derived metrics, such as "GPUW / PackageW". Expressions are made of
+// numbers, metric names, string literals, the operators + - * / with the
+// usual precedence, parentheses and function calls. Nothing but the
+// variables and functions handed to Eval can be reached from an expression.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrDivisionByZero = errors.New("division by zero")
+
+// Value is a function argument: either a number or a string literal.
+type Value struct {
+	Number   float64
+	String   string
+	IsString bool
+}
+
+// Func is a function callable from an expression.
+type Func func(args []Value) (float64, error)
+
+// Env holds the variables and functions an expression is evaluated with.
+// Functions not found in Funcs fall back to the built-ins min, max and abs.
+type Env struct {
+	Vars  map[string]float64
+	Funcs map[string]Func
+}
+
+// Builtins are the functions available to every expression.
+var Builtins = map[string]Func{
+	"min": func(args []Value) (float64, error) {
+		return fold(args, math.Min)
+	},
+	"max": func(args []Value) (float64, error) {
+		return fold(args, math.Max)
+	},
+	"abs": func(args []Value) (float64, error) {
+		if len(args) != 1 || args[0].IsString {
+			return 0, errors.New("abs takes one number")
+		}
+		return math.Abs(args[0].Number), nil
+	},
+}
+
+func fold(args []Value, f func(a, b float64) float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, errors.New("expected at least one argument")
+	}
+	var result float64
+	for i, arg := range args {
+		if arg.IsString {
+			return 0, errors.New("expected numbers")
+		}
+		if i == 0 {
+			result = arg.Number
+		} else {
+			result = f(result, arg.Number)
+		}
+	}
+	return result, nil
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	src   string
+	root  node
+	vars  []string
+	funcs []string
+}
+
+// Parse parses src.
+func Parse(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+	}
+	e := &Expr{src: src, root: root}
+	collect(root, e)
+	return e, nil
+}
+
+// MustParse is like Parse but panics on error.
+func MustParse(src string) *Expr {
+	e, err := Parse(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars returns the variables the expression refers to, sorted.
+func (e *Expr) Vars() []string {
+	return slices.Clone(e.vars)
+}
+
+// Funcs returns the functions the expression calls, sorted.
+func (e *Expr) Funcs() []string {
+	return slices.Clone(e.funcs)
+}
+
+// Eval evaluates the expression. Unknown variables and functions, string
+// literals used as numbers and divisions by zero are errors.
+func (e *Expr) Eval(env Env) (float64, error) {
+	v, err := e.root.eval(&env)
+	if err != nil {
+		return 0, err
+	}
+	if v.IsString {
+		return 0, errors.New("expression evaluates to a string")
+	}
+	return v.Number, nil
+}
+
+func collect(n node, e *Expr) {
+	add := func(list *[]string, name string) {
+		i := sort.SearchStrings(*list, name)
+		if i == len(*list) || (*list)[i] != name {
+			*list = slices.Insert(*list, i, name)
+		}
+	}
+	switch n := n.(type) {
+	case variable:
+		add(&e.vars, string(n))
+	case call:
+		add(&e.funcs, n.name)
+		for _, arg := range n.args {
+			collect(arg, e)
+		}
+	case unary:
+		collect(n.operand, e)
+	case binary:
+		collect(n.left, e)
+		collect(n.right, e)
+	}
+}
+
+type node interface {
+	eval(env *Env) (Value, error)
+}
+
+type literal Value
+
+func (n literal) eval(*Env) (Value, error) {
+	return Value(n), nil
+}
+
+type variable string
+
+func (n variable) eval(env *Env) (Value, error) {
+	v, ok := env.Vars[string(n)]
+	if !ok {
+		return Value{}, fmt.Errorf("unknown variable %q", string(n))
+	}
+	return Value{Number: v}, nil
+}
+
+type call struct {
+	name string
+	args []node
+}
+
+func (n call) eval(env *Env) (Value, error) {
+	f, ok := env.Funcs[n.name]
+	if !ok {
+		f, ok = Builtins[n.name]
+	}
+	if !ok {
+		return Value{}, fmt.Errorf("unknown function %q", n.name)
+	}
+	args := make([]Value, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+	result, err := f(args)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return Value{Number: result}, nil
+}
+
+type unary struct {
+	operand node
+}
+
+func (n unary) eval(env *Env) (Value, error) {
+	v, err := number(n.operand, env)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Number: -v}, nil
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (n binary) eval(env *Env) (Value, error) {
+	left, err := number(n.left, env)
+	if err != nil {
+		return Value{}, err
+	}
+	right, err := number(n.right, env)
+	if err != nil {
+		return Value{}, err
+	}
+	switch n.op {
+	case '+':
+		return Value{Number: left + right}, nil
+	case '-':
+		return Value{Number: left - right}, nil
+	case '*':
+		return Value{Number: left * right}, nil
+	}
+	if right == 0 {
+		return Value{}, ErrDivisionByZero
+	}
+	return Value{Number: left / right}, nil
+}
+
+func number(n node, env *Env) (float64, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if v.IsString {
+		return 0, fmt.Errorf("string %q used as a number", v.String)
+	}
+	return v.Number, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/(),", c):
+			tokens = append(tokens, token{tokenOp, string(c), i})
+			i++
+		case c == '.' || unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (src[i] == '.' || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokenString, src[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q, got %s at offset %d", op, t, t.pos)
+	}
+	return nil
+}
+
+// expr parses a sum: term (('+' | '-') term)*.
+func (p *parser) expr() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept("+"):
+			op = '+'
+		case p.accept("-"):
+			op = '-'
+		default:
+			return left, nil
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+// term parses a product: unary (('*' | '/') unary)*.
+func (p *parser) term() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept("*"):
+			op = '*'
+		case p.accept("/"):
+			op = '/'
+		default:
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s at offset %d", t, t.pos)
+		}
+		return literal{Number: v}, nil
+	case tokenString:
+		return literal{String: t.text, IsString: true}, nil
+	case tokenIdent:
+		if !p.accept("(") {
+			return variable(t.text), nil
+		}
+		c := call{name: t.text}
+		if p.accept(")") {
+			return c, nil
+		}
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.accept(")") {
+				return c, nil
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	case tokenOp:
+		if t.text == "(" {
+			n, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+}
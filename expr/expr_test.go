@@ -0,0 +1,69 @@
+package expr
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Vars: map[string]float64{"GPUW": 2, "PackageW": 8, "PClusterActive": 50, "PClusterFreqMHz": 3000, "CPUW": 5},
+		Funcs: map[string]Func{
+			"count": func(args []Value) (float64, error) { return float64(len(args)), nil },
+		},
+	}
+	for src, want := range map[string]float64{
+		"GPUW / PackageW":                         0.25,
+		"PClusterActive * PClusterFreqMHz / CPUW": 30000,
+		"1 + 2 * 3":                               7,
+		"(1 + 2) * 3":                             9,
+		"10 - 4 - 3":                              3,
+		"-GPUW + -(-1)":                           -1,
+		"max(GPUW, CPUW, 1.5) + min(3, PackageW)": 8,
+		"abs(-2.5)":                           2.5,
+		`count("Safari", "WindowServer") * 2`: 4,
+		"  count() ":                          0,
+	} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%q = %v, %v; want %v", src, got, err, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{"", "1 +", "(1", "1 2", "f(1,", `"open`, "a $ b", "1..2"} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("expected an error parsing %q", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := Env{Vars: map[string]float64{"zero": 0}}
+	if _, err := MustParse("1 / zero").Eval(env); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("got %v, want division by zero", err)
+	}
+	for _, src := range []string{"missing", "nope(1)", `"text"`, `"a" + 1`, `abs("a")`} {
+		if _, err := MustParse(src).Eval(env); err == nil {
+			t.Errorf("expected an error evaluating %q", src)
+		}
+	}
+}
+
+func TestVarsAndFuncs(t *testing.T) {
+	e := MustParse("max(b, a) / b + sum(c)")
+	if vars := e.Vars(); !slices.Equal(vars, []string{"a", "b", "c"}) {
+		t.Errorf("got vars %q", vars)
+	}
+	if funcs := e.Funcs(); !slices.Equal(funcs, []string{"max", "sum"}) {
+		t.Errorf("got funcs %q", funcs)
+	}
+}
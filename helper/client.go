@@ -0,0 +1,209 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/collector"
+)
+
+// requestTimeout bounds how long a client waits for the answer to a
+// request.
+const requestTimeout = 5 * time.Second
+
+var errDisconnected = errors.New("disconnected from helper")
+
+// Client is a connection to a helper. It offers the same buses, history
+// and controller as a local collector.
+type Client struct {
+	conn    io.ReadWriteCloser
+	decoder *json.Decoder
+
+	Hello     Hello
+	Snapshots *bus.Bus[collector.Snapshot]
+	Health    *bus.Bus[collector.Health]
+	// History records every snapshot received.
+	History *collector.History
+
+	mu       sync.Mutex
+	encoder  *json.Encoder
+	interval int
+	nextID   int
+	pending  map[int]chan error
+	closed   bool
+}
+
+// Dial connects to the helper listening on path and reads its hello.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to helper: %w", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	c, err := NewClient(conn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return c, nil
+}
+
+// NewClient reads the hello of the helper at the other end of conn. conn is
+// closed if that fails.
+func NewClient(conn io.ReadWriteCloser) (*Client, error) {
+	c := &Client{
+		conn:      conn,
+		decoder:   json.NewDecoder(conn),
+		encoder:   json.NewEncoder(conn),
+		Snapshots: bus.New[collector.Snapshot](),
+		Health:    bus.New[collector.Health](),
+		pending:   make(map[int]chan error),
+	}
+	var msg Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read hello: %w", err)
+	}
+	if msg.Type != TypeHello || msg.Hello == nil {
+		conn.Close()
+		return nil, fmt.Errorf("expected hello, got %q", msg.Type)
+	}
+	if msg.Hello.Version != Version {
+		conn.Close()
+		return nil, fmt.Errorf("helper speaks protocol version %d, want %d", msg.Hello.Version, Version)
+	}
+
+	derived, err := collector.DerivedMetrics(msg.Hello.Derived)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.Hello = *msg.Hello
+	c.interval = msg.Hello.Interval
+	c.History = collector.NewHistory(collector.DefaultHistoryOptions(), derived...)
+	return c, nil
+}
+
+// Run relays messages from the helper until done is closed or the
+// connection is lost, and then closes both buses. A lost connection is
+// reported as a failed health state before it is returned.
+func (c *Client) Run(done <-chan struct{}) error {
+	defer c.Snapshots.Close()
+	defer c.Health.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		c.conn.Close()
+	}()
+
+	for {
+		var msg Message
+		err := c.decoder.Decode(&msg)
+		if err != nil {
+			c.fail()
+			select {
+			case <-done:
+				return nil
+			default:
+			}
+			c.Health.Publish(collector.Health{State: collector.StateFailed, LastError: errDisconnected.Error()})
+			return fmt.Errorf("%w: %v", errDisconnected, err)
+		}
+
+		switch msg.Type {
+		case TypeSnapshot:
+			if msg.Snapshot == nil {
+				continue
+			}
+			c.mu.Lock()
+			c.interval = msg.Snapshot.Interval
+			c.mu.Unlock()
+			c.History.Record(msg.Snapshot)
+			c.Snapshots.Publish(*msg.Snapshot)
+		case TypeHealth:
+			if msg.Health != nil {
+				c.Health.Publish(*msg.Health)
+			}
+		case TypeResult:
+			c.mu.Lock()
+			result, ok := c.pending[msg.ID]
+			delete(c.pending, msg.ID)
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if msg.Error != "" {
+				result <- errors.New(msg.Error)
+			} else {
+				result <- nil
+			}
+		}
+	}
+}
+
+// fail ends every pending request.
+func (c *Client) fail() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, result := range c.pending {
+		result <- errDisconnected
+		delete(c.pending, id)
+	}
+}
+
+// Interval returns the sample interval of the latest snapshot.
+func (c *Client) Interval() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interval
+}
+
+// SetInterval asks the helper to change the sample interval. The change
+// applies to every client of the helper, and a shared helper only accepts
+// it when started with --allow-interval-changes.
+func (c *Client) SetInterval(interval int) error {
+	result := make(chan error, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errDisconnected
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = result
+	err := c.encoder.Encode(Message{Type: TypeSetInterval, ID: id, Interval: interval})
+	if err != nil {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(requestTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return errors.New("helper did not answer")
+	}
+}
+
+// Close closes the connection. Run returns once it notices.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,235 @@
+package helper
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+// fakeController stands in for a collector.
+type fakeController struct {
+	mu       sync.Mutex
+	interval int
+}
+
+func (f *fakeController) Interval() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.interval
+}
+
+func (f *fakeController) SetInterval(interval int) error {
+	if interval < collector.MinInterval {
+		return errors.New("too short")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.interval = interval
+	return nil
+}
+
+func startServer(t *testing.T, allowSetInterval bool) (*Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "helper.sock")
+	l, err := Listen(path, 0o660, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := collector.DefaultOptions(1000)
+	opts.Derived = []collector.Derived{{Name: "gpu_share", Expr: "GPUW / PackageW"}}
+	s := &Server{
+		Hello:            NewHello(&soc.SocInfo{Name: "Apple M1 Pro", ECoreCount: 2, PCoreCount: 8}, opts),
+		Snapshots:        bus.New[collector.Snapshot](),
+		Health:           bus.New[collector.Health](),
+		Controller:       &fakeController{interval: 1000},
+		AllowSetInterval: allowSetInterval,
+	}
+
+	done := make(chan struct{})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l, done) }()
+	t.Cleanup(func() {
+		close(done)
+		if err := <-served; err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return s, path
+}
+
+func TestClientReceivesSnapshots(t *testing.T) {
+	s, path := startServer(t, true)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o660 {
+		t.Errorf("got socket mode %o, want 660", perm)
+	}
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Hello.SocInfo.Name != "Apple M1 Pro" || c.Interval() != 1000 {
+		t.Errorf("unexpected hello %+v", c.Hello)
+	}
+	if opts := c.Hello.CollectorOptions(); len(opts.Derived) != 1 || !opts.Enabled("gpu_power") {
+		t.Errorf("unexpected collector options %+v", opts)
+	}
+
+	snapshots := c.Snapshots.Subscribe("test", 4, bus.DropOldest)
+	health := c.Health.Subscribe("test", 4, bus.DropOldest)
+	done := make(chan struct{})
+	ran := make(chan error, 1)
+	go func() { ran <- c.Run(done) }()
+
+	// The server may not have subscribed the client yet, so keep
+	// publishing until a snapshot arrives.
+	now := time.Now()
+	sent := collector.Snapshot{
+		Time:     now,
+		Interval: 1000,
+		Updated:  map[collector.Source]time.Time{collector.SourcePower: now},
+		Derived:  map[string]float64{"gpu_share": 0.5},
+	}
+	sent.CPU.PackageW = 4
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	var got collector.Snapshot
+wait:
+	for {
+		select {
+		case got = <-snapshots.C:
+			break wait
+		case <-ticker.C:
+			s.Snapshots.Publish(sent)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+	}
+	if got.CPU.PackageW != 4 || !got.Time.Equal(now) {
+		t.Errorf("unexpected snapshot %+v", got)
+	}
+	if p, ok := c.History.Latest("gpu_share"); !ok || p.Avg != 0.5 {
+		t.Errorf("expected the derived metric in the client history, got %+v", p)
+	}
+
+	s.Health.Publish(collector.Health{State: collector.StateRestarting, Restarts: 1})
+	if h := <-health.C; h.State != collector.StateRestarting || h.Restarts != 1 {
+		t.Errorf("unexpected health %+v", h)
+	}
+
+	if err := c.SetInterval(250); err != nil {
+		t.Fatal(err)
+	}
+	if interval := s.Controller.Interval(); interval != 250 {
+		t.Errorf("got interval %d, want 250", interval)
+	}
+	if err := c.SetInterval(10); err == nil || err.Error() != "too short" {
+		t.Errorf("expected the server error, got %v", err)
+	}
+
+	close(done)
+	if err := <-ran; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+}
+
+func TestServerRefusesIntervalChanges(t *testing.T) {
+	s, path := startServer(t, false)
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	ran := make(chan error, 1)
+	go func() { ran <- c.Run(done) }()
+
+	if err := c.SetInterval(250); err == nil {
+		t.Error("expected the interval change to be refused")
+	}
+	if interval := s.Controller.Interval(); interval != 1000 {
+		t.Errorf("got interval %d, want 1000", interval)
+	}
+
+	close(done)
+	if err := <-ran; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+}
+
+func TestClientReportsLostConnection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helper.sock")
+	l, err := Listen(path, 0o600, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Snapshots:  bus.New[collector.Snapshot](),
+		Health:     bus.New[collector.Health](),
+		Controller: &fakeController{interval: 1000},
+	}
+	done := make(chan struct{})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l, done) }()
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := c.Health.Subscribe("test", 4, bus.DropOldest)
+	ran := make(chan error, 1)
+	go func() { ran <- c.Run(make(chan struct{})) }()
+
+	close(done)
+	<-served
+	if err := <-ran; !errors.Is(err, errDisconnected) {
+		t.Errorf("got %v, want a lost connection", err)
+	}
+	if h := <-health.C; h.State != collector.StateFailed {
+		t.Errorf("unexpected health %+v", h)
+	}
+	if err := c.SetInterval(500); !errors.Is(err, errDisconnected) {
+		t.Errorf("got %v, want a lost connection", err)
+	}
+}
+
+func TestListenRefusesRunningHelper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helper.sock")
+	l, err := Listen(path, 0o600, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path, 0o600, -1); err == nil {
+		t.Error("expected an error while another helper listens")
+	}
+	// Leave the socket behind the way a crashed helper would.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Listen(path, 0o600, -1)
+	if err != nil {
+		t.Fatalf("expected a fresh listener, got %v", err)
+	}
+	l.Close()
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(file, 0o600, -1); err == nil {
+		t.Error("expected an error for a path that is not a socket")
+	}
+}
@@ -0,0 +1,109 @@
+// Package helper shares one collector between several clients. The server
+// side runs next to a privileged collector and publishes its snapshots on a
+// Unix domain socket; clients connect without root and get the same buses,
+// history and controller a local collector offers.
+//
+// The protocol is newline-delimited JSON. The server opens every
+// connection with a hello message and then streams snapshot and health
+// messages. Clients may send set_interval requests, which the server
+// answers with a result message carrying the same id.
+//
+// A helper started over stdin and stdout by a process that is about to drop
+// root waits for a configure message with the settings of its parent before
+// it starts collecting and says hello.
+package helper
+
+import (
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/context-labs/mactop/v2/soc"
+)
+
+// Version is the protocol version sent in the hello message.
+const Version = 1
+
+// DefaultSocket is where mactop helperd listens unless told otherwise.
+const DefaultSocket = "/var/run/mactop.sock"
+
+const (
+	TypeHello       = "hello"
+	TypeSnapshot    = "snapshot"
+	TypeHealth      = "health"
+	TypeSetInterval = "set_interval"
+	TypeResult      = "result"
+	TypeConfigure   = "configure"
+)
+
+// Message is a single line of the protocol. Type selects which of the other
+// fields are set.
+type Message struct {
+	Type     string              `json:"type"`
+	ID       int                 `json:"id,omitempty"`
+	Hello    *Hello              `json:"hello,omitempty"`
+	Snapshot *collector.Snapshot `json:"snapshot,omitempty"`
+	Health   *collector.Health   `json:"health,omitempty"`
+	Settings *Settings           `json:"settings,omitempty"`
+	Interval int                 `json:"interval,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// Settings are the collector options a client chooses.
+type Settings struct {
+	Interval     int                 `json:"interval"`
+	Samplers     []string            `json:"samplers"`
+	ProcessStats []string            `json:"process_stats"`
+	Derived      []collector.Derived `json:"derived,omitempty"`
+	Backend      string              `json:"backend,omitempty"`
+	SysRoot      string              `json:"sys_root,omitempty"`
+	// RefreshHardware asks a helper to detect the hardware again instead
+	// of using its cache. It is only read from configure messages.
+	RefreshHardware bool `json:"refresh_hardware,omitempty"`
+}
+
+// NewSettings returns the settings of opts.
+func NewSettings(opts collector.Options) Settings {
+	return Settings{
+		Interval:     opts.Interval,
+		Samplers:     opts.Samplers,
+		ProcessStats: opts.ProcessStats,
+		Derived:      opts.Derived,
+		Backend:      opts.Backend,
+		SysRoot:      opts.SysRoot,
+	}
+}
+
+// Apply returns opts with the settings applied.
+func (s Settings) Apply(opts collector.Options) collector.Options {
+	opts.Interval = s.Interval
+	opts.Samplers = s.Samplers
+	opts.ProcessStats = s.ProcessStats
+	opts.Derived = s.Derived
+	opts.Backend = s.Backend
+	opts.SysRoot = s.SysRoot
+	return opts
+}
+
+// Hello describes the machine and the collector a server runs.
+type Hello struct {
+	Version int          `json:"version"`
+	SocInfo *soc.SocInfo `json:"soc_info"`
+	Settings
+	Unprivileged bool `json:"unprivileged,omitempty"`
+}
+
+// NewHello describes a collector started with opts.
+func NewHello(socInfo *soc.SocInfo, opts collector.Options) Hello {
+	return Hello{
+		Version:      Version,
+		SocInfo:      socInfo,
+		Settings:     NewSettings(opts),
+		Unprivileged: opts.Unprivileged,
+	}
+}
+
+// CollectorOptions returns the options of the remote collector, as far as
+// they matter to a client.
+func (h Hello) CollectorOptions() collector.Options {
+	opts := h.Apply(collector.DefaultOptions(h.Interval))
+	opts.Unprivileged = h.Unprivileged
+	return opts
+}
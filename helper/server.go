@@ -0,0 +1,177 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/collector"
+	"github.com/sirupsen/logrus"
+)
+
+// Server publishes the snapshots and health changes of a collector to every
+// connected client and relays their interval changes to it.
+type Server struct {
+	Hello      Hello
+	Snapshots  *bus.Bus[collector.Snapshot]
+	Health     *bus.Bus[collector.Health]
+	Controller collector.Controller
+	// AllowSetInterval lets clients change the interval. It applies to
+	// every client, so a shared helper refuses changes unless asked to.
+	AllowSetInterval bool
+}
+
+// Listen creates the socket at path, replacing a stale one, and restricts
+// it to mode. A gid of -1 keeps the group of the current process.
+func Listen(path string, mode fs.FileMode, gid int) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another helper is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Serve accepts clients on l until done is closed, then closes l and waits
+// for every connection to end.
+func (s *Server) Serve(l net.Listener, done <-chan struct{}) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-done
+		l.Close()
+	}()
+
+	for id := 1; ; id++ {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept client: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(conn, fmt.Sprintf("client-%d", id), done)
+		}()
+	}
+}
+
+// ServeStream serves a single client connected through conn, such as the
+// parent of a helper talking over stdin and stdout, until either side
+// stops.
+func (s *Server) ServeStream(conn io.ReadWriteCloser, done <-chan struct{}) {
+	s.serveConn(conn, "stream", done)
+}
+
+func (s *Server) serveConn(conn io.ReadWriteCloser, name string, done <-chan struct{}) {
+	defer conn.Close()
+	logrus.Debugf("helper: %s connected", name)
+	defer logrus.Debugf("helper: %s disconnected", name)
+
+	snapshots := s.Snapshots.Subscribe(name, 16, bus.DropOldest)
+	defer snapshots.Unsubscribe()
+	health := s.Health.Subscribe(name, 4, bus.DropOldest)
+	defer health.Unsubscribe()
+
+	results := make(chan Message)
+	closed := make(chan struct{})
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		defer close(closed)
+		decoder := json.NewDecoder(conn)
+		for {
+			var req Message
+			if err := decoder.Decode(&req); err != nil {
+				return
+			}
+			select {
+			case results <- s.handle(req):
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(conn)
+	hello := s.Hello
+	hello.Version = Version
+	hello.Interval = s.Controller.Interval()
+	if err := encoder.Encode(Message{Type: TypeHello, Hello: &hello}); err != nil {
+		return
+	}
+	for {
+		var msg Message
+		select {
+		case snapshot, ok := <-snapshots.C:
+			if !ok {
+				return
+			}
+			msg = Message{Type: TypeSnapshot, Snapshot: &snapshot}
+		case h, ok := <-health.C:
+			if !ok {
+				return
+			}
+			msg = Message{Type: TypeHealth, Health: &h}
+		case msg = <-results:
+		case <-closed:
+			return
+		case <-done:
+			return
+		}
+		if err := encoder.Encode(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req Message) Message {
+	result := Message{Type: TypeResult, ID: req.ID}
+	switch req.Type {
+	case TypeSetInterval:
+		if !s.AllowSetInterval {
+			result.Error = "the helper does not allow interval changes, see helperd --allow-interval-changes"
+		} else if err := s.Controller.SetInterval(req.Interval); err != nil {
+			result.Error = err.Error()
+		}
+	default:
+		result.Error = fmt.Sprintf("unknown request %q", req.Type)
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream is a connection made of a separate reader and writer, such as the
+// pipes to a child process.
+type Stream struct {
+	io.Reader
+	io.Writer
+	// Closers are closed in order by Close.
+	Closers []io.Closer
+}
+
+func (s *Stream) Close() error {
+	var errs []error
+	for _, c := range s.Closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Configure sends the settings a helper started over a stream waits for.
+func Configure(w io.Writer, settings Settings) error {
+	return json.NewEncoder(w).Encode(Message{Type: TypeConfigure, Settings: &settings})
+}
+
+// ReadSettings reads the configure message a helper started over a stream
+// begins with. r is left at the start of the next message.
+func ReadSettings(r *bufio.Reader) (Settings, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to read settings: %w", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return Settings{}, fmt.Errorf("failed to parse settings: %w", err)
+	}
+	if msg.Type != TypeConfigure || msg.Settings == nil {
+		return Settings{}, fmt.Errorf("expected configure, got %q", msg.Type)
+	}
+	return *msg.Settings, nil
+}
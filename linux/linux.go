@@ -0,0 +1,440 @@
+// Package linux reads the metrics mactop shows from procfs and sysfs. Every
+// path is resolved below FS.Root so tests can point it at a fake tree.
+// Readers return raw counters; CoreUsage, Power and Rates turn two readings
+// into percentages and per-second values.
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+// FS is a procfs and sysfs tree.
+type FS struct {
+	// Root is prepended to /proc and /sys. Empty means "/".
+	Root string
+}
+
+func (fs FS) path(elem ...string) string {
+	root := fs.Root
+	if root == "" {
+		root = "/"
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+func (fs FS) readString(elem ...string) (string, error) {
+	data, err := os.ReadFile(fs.path(elem...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (fs FS) readUint(elem ...string) (uint64, error) {
+	s, err := fs.readString(elem...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// CPUTimes are the jiffies a core spent in each state, from /proc/stat.
+type CPUTimes struct {
+	User, Nice, System, Idle, Iowait, IRQ, SoftIRQ, Steal uint64
+}
+
+func (t CPUTimes) total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.Iowait + t.IRQ + t.SoftIRQ + t.Steal
+}
+
+func (t CPUTimes) idle() uint64 {
+	return t.Idle + t.Iowait
+}
+
+// ReadCPUTimes returns the times of every core, in core order.
+func (fs FS) ReadCPUTimes() ([]CPUTimes, error) {
+	data, err := os.ReadFile(fs.path("proc", "stat"))
+	if err != nil {
+		return nil, err
+	}
+	var times []CPUTimes
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// The aggregate "cpu" line is skipped, cores are "cpu0", "cpu1"...
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		var values [8]uint64
+		for i := range values {
+			if values[i], err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+				return nil, fmt.Errorf("bad /proc/stat line %q: %w", scanner.Text(), err)
+			}
+		}
+		times = append(times, CPUTimes{values[0], values[1], values[2], values[3], values[4], values[5], values[6], values[7]})
+	}
+	return times, nil
+}
+
+// CoreUsage returns the usage of every core in percent between two
+// readings. It returns nil if the number of cores changed.
+func CoreUsage(prev, cur []CPUTimes) []float64 {
+	if len(prev) != len(cur) {
+		return nil
+	}
+	usage := make([]float64, len(cur))
+	for i := range cur {
+		total := float64(cur[i].total()) - float64(prev[i].total())
+		idle := float64(cur[i].idle()) - float64(prev[i].idle())
+		if total > 0 {
+			usage[i] = min(max((total-idle)/total*100, 0), 100)
+		}
+	}
+	return usage
+}
+
+var cpuDir = regexp.MustCompile(`^cpu(\d+)$`)
+
+// ReadCoreFreqs returns the current frequency of every core in MHz, in
+// core order. Cores without cpufreq report 0.
+func (fs FS) ReadCoreFreqs() ([]int, error) {
+	entries, err := os.ReadDir(fs.path("sys", "devices", "system", "cpu"))
+	if err != nil {
+		return nil, err
+	}
+	type core struct{ index, mhz int }
+	var cores []core
+	for _, e := range entries {
+		m := cpuDir.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		khz, err := fs.readUint("sys", "devices", "system", "cpu", e.Name(), "cpufreq", "scaling_cur_freq")
+		if err != nil {
+			khz = 0
+		}
+		cores = append(cores, core{index, int(khz / 1000)})
+	}
+	sort.Slice(cores, func(i, j int) bool { return cores[i].index < cores[j].index })
+	freqs := make([]int, len(cores))
+	for i, c := range cores {
+		freqs[i] = c.mhz
+	}
+	return freqs, nil
+}
+
+// RAPLZone is an energy counter of /sys/class/powercap.
+type RAPLZone struct {
+	// Zone is the sysfs name, such as "intel-rapl:0:1".
+	Zone string
+	// Name is the domain: package-0, core, uncore, dram or psys.
+	Name     string
+	EnergyUJ uint64
+	// MaxEnergyUJ is where the counter wraps around.
+	MaxEnergyUJ uint64
+}
+
+// raplZone matches the packages and their subzones. AMD chips use the same
+// names; the intel-rapl-mmio zones repeat the package counter and are left
+// out so it is not counted twice.
+var raplZone = regexp.MustCompile(`^intel-rapl:\d+(:\d+)?$`)
+
+// ReadRAPL returns every RAPL zone, Intel or AMD, sorted by zone. Zones
+// whose counter cannot be read, usually because that needs root, are left
+// out.
+func (fs FS) ReadRAPL() ([]RAPLZone, error) {
+	dir := fs.path("sys", "class", "powercap")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var zones []RAPLZone
+	for _, e := range entries {
+		name := e.Name()
+		if !raplZone.MatchString(name) {
+			continue
+		}
+		domain, err := fs.readString("sys", "class", "powercap", name, "name")
+		if err != nil {
+			continue
+		}
+		energy, err := fs.readUint("sys", "class", "powercap", name, "energy_uj")
+		if err != nil {
+			continue
+		}
+		maxEnergy, _ := fs.readUint("sys", "class", "powercap", name, "max_energy_range_uj")
+		zones = append(zones, RAPLZone{Zone: name, Name: domain, EnergyUJ: energy, MaxEnergyUJ: maxEnergy})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	return zones, nil
+}
+
+// Power returns the average power over seconds between two RAPL readings,
+// mapped onto the powermetrics rails: packages to PackageW, cores to CPUW,
+// uncore (the integrated GPU) to GPUW and memory to DRAMW. Without a core
+// domain CPUW is the package power.
+func Power(prev, cur []RAPLZone, seconds float64) parser.CPUMetrics {
+	var m parser.CPUMetrics
+	if seconds <= 0 {
+		return m
+	}
+	before := make(map[string]RAPLZone, len(prev))
+	for _, z := range prev {
+		before[z.Zone] = z
+	}
+	hasCore := false
+	for _, z := range cur {
+		p, ok := before[z.Zone]
+		if !ok {
+			continue
+		}
+		delta := float64(z.EnergyUJ) - float64(p.EnergyUJ)
+		if z.EnergyUJ < p.EnergyUJ {
+			delta += float64(z.MaxEnergyUJ)
+		}
+		watts := delta / 1e6 / seconds
+		switch {
+		case strings.HasPrefix(z.Name, "package"):
+			m.PackageW += watts
+		case z.Name == "core":
+			m.CPUW += watts
+			hasCore = true
+		case z.Name == "uncore":
+			m.GPUW += watts
+		case z.Name == "dram":
+			m.DRAMW += watts
+		}
+	}
+	if !hasCore {
+		m.CPUW = m.PackageW
+	}
+	return m
+}
+
+// ReadTemperatures returns every hwmon temperature in degrees Celsius,
+// keyed by "<chip> <label>", falling back to the sensor file name when a
+// sensor has no label.
+func (fs FS) ReadTemperatures() (map[string]float64, error) {
+	dir := fs.path("sys", "class", "hwmon")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	temps := make(map[string]float64)
+	for _, e := range entries {
+		chip, err := fs.readString("sys", "class", "hwmon", e.Name(), "name")
+		if err != nil {
+			chip = e.Name()
+		}
+		files, err := filepath.Glob(filepath.Join(dir, e.Name(), "temp*_input"))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			sensor := strings.TrimSuffix(filepath.Base(file), "_input")
+			value, err := fs.readString("sys", "class", "hwmon", e.Name(), sensor+"_input")
+			if err != nil {
+				continue
+			}
+			milli, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			label, err := fs.readString("sys", "class", "hwmon", e.Name(), sensor+"_label")
+			if err != nil || label == "" {
+				label = sensor
+			}
+			temps[chip+" "+label] = float64(milli) / 1000
+		}
+	}
+	return temps, nil
+}
+
+var cardDir = regexp.MustCompile(`^card\d+$`)
+
+// ReadGPU returns the load and frequency of the first DRM card that reports
+// them: gpu_busy_percent and pp_dpm_sclk for amdgpu, gt_cur_freq_mhz for
+// i915. ok is false when no card does.
+func (fs FS) ReadGPU() (gpu parser.GPUMetrics, ok bool, err error) {
+	entries, err := os.ReadDir(fs.path("sys", "class", "drm"))
+	if err != nil {
+		return gpu, false, err
+	}
+	for _, e := range entries {
+		if !cardDir.MatchString(e.Name()) {
+			continue
+		}
+		if busy, err := fs.readUint("sys", "class", "drm", e.Name(), "device", "gpu_busy_percent"); err == nil {
+			gpu.Active = float64(busy)
+			ok = true
+		}
+		if mhz, err := fs.readUint("sys", "class", "drm", e.Name(), "gt_cur_freq_mhz"); err == nil {
+			gpu.FreqMHz = int(mhz)
+			ok = true
+		} else if sclk, err := fs.readString("sys", "class", "drm", e.Name(), "device", "pp_dpm_sclk"); err == nil {
+			// The current level is marked with a star: "1: 1800Mhz *".
+			for _, line := range strings.Split(sclk, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 3 && fields[2] == "*" {
+					mhz, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(fields[1]), "mhz"))
+					if err == nil {
+						gpu.FreqMHz = mhz
+						ok = true
+					}
+				}
+			}
+		}
+		if ok {
+			return gpu, true, nil
+		}
+	}
+	return gpu, false, nil
+}
+
+// ReadMemory returns memory and swap usage from /proc/meminfo.
+func (fs FS) ReadMemory() (parser.MemoryMetrics, error) {
+	var m parser.MemoryMetrics
+	data, err := os.ReadFile(fs.path("proc", "meminfo"))
+	if err != nil {
+		return m, err
+	}
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, rest, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[key] = kb * 1024
+	}
+	m.Total = values["MemTotal"]
+	m.Available = values["MemAvailable"]
+	if m.Available > m.Total {
+		m.Available = m.Total
+	}
+	m.Used = m.Total - m.Available
+	m.SwapTotal = values["SwapTotal"]
+	if free := values["SwapFree"]; free <= m.SwapTotal {
+		m.SwapUsed = m.SwapTotal - free
+	}
+	return m, nil
+}
+
+// NetDiskCounters are cumulative network and disk counters.
+type NetDiskCounters struct {
+	PacketsSent, BytesSent, PacketsRecv, BytesRecv uint64
+	Reads, ReadBytes, Writes, WriteBytes           uint64
+}
+
+// ReadNetDisk sums /proc/net/dev over every interface but loopback, and
+// /proc/diskstats over every whole disk listed in /sys/block except loop
+// and RAM devices.
+func (fs FS) ReadNetDisk() (NetDiskCounters, error) {
+	var c NetDiskCounters
+	data, err := os.ReadFile(fs.path("proc", "net", "dev"))
+	if err != nil {
+		return c, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		iface, rest, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 10 {
+			continue
+		}
+		c.BytesRecv += parseUint(fields[0])
+		c.PacketsRecv += parseUint(fields[1])
+		c.BytesSent += parseUint(fields[8])
+		c.PacketsSent += parseUint(fields[9])
+	}
+
+	data, err = os.ReadFile(fs.path("proc", "diskstats"))
+	if err != nil {
+		return c, err
+	}
+	scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		// Only disks backed by hardware have a device link. Partitions are
+		// not in /sys/block, and loop, ram, zram, dm and md devices would
+		// count the I/O of the disks underneath them again.
+		if _, err := os.Stat(fs.path("sys", "block", fields[2], "device")); err != nil {
+			continue
+		}
+		// Sectors are always 512 bytes in diskstats.
+		c.Reads += parseUint(fields[3])
+		c.ReadBytes += parseUint(fields[5]) * 512
+		c.Writes += parseUint(fields[7])
+		c.WriteBytes += parseUint(fields[9]) * 512
+	}
+	return c, nil
+}
+
+func parseUint(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
+}
+
+// Rates returns the activity between two readings taken seconds apart.
+// Counters that went backwards, for example because an interface went
+// away, count as idle.
+func Rates(prev, cur NetDiskCounters, seconds float64) parser.NetDiskMetrics {
+	rate := func(now, before uint64) float64 {
+		if now < before || seconds <= 0 {
+			return 0
+		}
+		return float64(now-before) / seconds
+	}
+	return parser.NetDiskMetrics{
+		OutPacketsPerSec:  rate(cur.PacketsSent, prev.PacketsSent),
+		OutBytesPerSec:    rate(cur.BytesSent, prev.BytesSent),
+		InPacketsPerSec:   rate(cur.PacketsRecv, prev.PacketsRecv),
+		InBytesPerSec:     rate(cur.BytesRecv, prev.BytesRecv),
+		ReadOpsPerSec:     rate(cur.Reads, prev.Reads),
+		ReadKBytesPerSec:  rate(cur.ReadBytes, prev.ReadBytes) / 1024,
+		WriteOpsPerSec:    rate(cur.Writes, prev.Writes),
+		WriteKBytesPerSec: rate(cur.WriteBytes, prev.WriteBytes) / 1024,
+	}
+}
+
+// ReadCPUModel returns the CPU model name from /proc/cpuinfo. Arm systems
+// that do not report one yield an empty name.
+func (fs FS) ReadCPUModel() (string, error) {
+	data, err := os.ReadFile(fs.path("proc", "cpuinfo"))
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", nil
+}
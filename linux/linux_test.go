@@ -0,0 +1,146 @@
+package linux
+
+import (
+	"math"
+	"testing"
+
+	"github.com/context-labs/mactop/v2/parser"
+)
+
+var testFS = FS{Root: "testdata/root"}
+
+func TestReadCPU(t *testing.T) {
+	times, err := testFS.ReadCPUTimes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || times[0].User != 2000 || times[1].Idle != 3500 {
+		t.Fatalf("unexpected times %+v", times)
+	}
+
+	next := []CPUTimes{times[0], times[1]}
+	next[0].User += 75
+	next[0].Idle += 25
+	next[1].Idle += 100
+	usage := CoreUsage(times, next)
+	if len(usage) != 2 || usage[0] != 75 || usage[1] != 0 {
+		t.Errorf("got usage %v, want [75 0]", usage)
+	}
+	if CoreUsage(times[:1], next) != nil {
+		t.Error("expected no usage when cores change")
+	}
+
+	if model, err := testFS.ReadCPUModel(); err != nil || model != "Intel(R) Core(TM) i7-8650U CPU @ 1.90GHz" {
+		t.Errorf("got model %q, %v", model, err)
+	}
+
+	freqs, err := testFS.ReadCoreFreqs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(freqs) != 2 || freqs[0] != 2400 || freqs[1] != 1200 {
+		t.Errorf("got frequencies %v, want [2400 1200]", freqs)
+	}
+}
+
+func TestReadRAPL(t *testing.T) {
+	zones, err := testFS.ReadRAPL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The intel-rapl-mmio:0 package repeats intel-rapl:0 and is left out.
+	if len(zones) != 4 || zones[0].Zone != "intel-rapl:0" || zones[0].Name != "package-0" || zones[3].Name != "dram" {
+		t.Fatalf("unexpected zones %+v", zones)
+	}
+
+	next := make([]RAPLZone, len(zones))
+	copy(next, zones)
+	next[0].EnergyUJ += 20e6 // package
+	next[1].EnergyUJ += 12e6 // core
+	next[2].EnergyUJ += 4e6  // uncore
+	// The DRAM counter wraps around.
+	next[3].EnergyUJ = 1e6
+	zones[3].EnergyUJ = zones[3].MaxEnergyUJ - 1e6
+	power := Power(zones, next, 2)
+	want := parser.CPUMetrics{PackageW: 10, CPUW: 6, GPUW: 2, DRAMW: 1}
+	if power.PackageW != want.PackageW || power.CPUW != want.CPUW || power.GPUW != want.GPUW || power.DRAMW != want.DRAMW {
+		t.Errorf("got %+v, want %+v", power, want)
+	}
+
+	// Without a core domain the package is all there is.
+	if p := Power(zones[:1], next[:1], 2); p.CPUW != 10 {
+		t.Errorf("got CPU power %v, want the package power", p.CPUW)
+	}
+}
+
+func TestReadSensors(t *testing.T) {
+	temps, err := testFS.ReadTemperatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"coretemp Package id 0": 55, "coretemp Core 0": 48.5, "nvme temp1": 38.85}
+	if len(temps) != len(want) {
+		t.Fatalf("got %v, want %v", temps, want)
+	}
+	for name, v := range want {
+		if math.Abs(temps[name]-v) > 1e-9 {
+			t.Errorf("got %s = %v, want %v", name, temps[name], v)
+		}
+	}
+
+	gpu, ok, err := testFS.ReadGPU()
+	if err != nil || !ok {
+		t.Fatalf("expected a GPU, got %v", err)
+	}
+	if gpu.Active != 37 || gpu.FreqMHz != 1800 {
+		t.Errorf("unexpected GPU %+v", gpu)
+	}
+}
+
+func TestReadMemoryAndNetDisk(t *testing.T) {
+	memory, err := testFS.ReadMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parser.MemoryMetrics{
+		Total:     16384000 * 1024,
+		Used:      8192000 * 1024,
+		Available: 8192000 * 1024,
+		SwapTotal: 4096000 * 1024,
+		SwapUsed:  1024000 * 1024,
+	}
+	if memory != want {
+		t.Errorf("got %+v, want %+v", memory, want)
+	}
+
+	counters, err := testFS.ReadNetDisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Loopback, loop devices, partitions and the dm-0 device on top of
+	// the disk are left out.
+	if counters.BytesRecv != 1024000 || counters.PacketsSent != 410 || counters.Reads != 2000 || counters.WriteBytes != 20000*512 {
+		t.Errorf("unexpected counters %+v", counters)
+	}
+
+	next := counters
+	next.BytesRecv += 2048
+	next.ReadBytes += 4096
+	next.Writes += 10
+	// An interface that went away makes the counter go backwards.
+	next.BytesSent = 0
+	rates := Rates(counters, next, 2)
+	if rates.InBytesPerSec != 1024 || rates.ReadKBytesPerSec != 2 || rates.WriteOpsPerSec != 5 || rates.OutBytesPerSec != 0 {
+		t.Errorf("unexpected rates %+v", rates)
+	}
+}
+
+func TestMissingTree(t *testing.T) {
+	fs := FS{Root: t.TempDir()}
+	if _, err := fs.ReadCPUTimes(); err == nil {
+		t.Error("expected an error without /proc/stat")
+	}
+	if _, ok, _ := fs.ReadGPU(); ok {
+		t.Error("expected no GPU")
+	}
+}
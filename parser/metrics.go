@@ -1,11 +1,8 @@
 package parser
 
 import (
-	"bufio"
+	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/sirupsen/logrus"
-	"os"
-	"os/exec"
 	"regexp"
 	"sort"
 	"strconv"
@@ -27,7 +24,7 @@ var (
 type CPUMetrics struct {
 	EClusterActive, EClusterFreqMHz, PClusterActive, PClusterFreqMHz                                                                                                                                                 int
 	ECores, PCores                                                                                                                                                                                                   []int
-	ANEW, CPUW, GPUW, PackageW                                                                                                                                                                                       float64
+	ANEW, CPUW, GPUW, PackageW, DRAMW                                                                                                                                                                                float64
 	E0ClusterActive, E0ClusterFreqMHz, E1ClusterActive, E1ClusterFreqMHz, P0ClusterActive, P0ClusterFreqMHz, P1ClusterActive, P1ClusterFreqMHz, P2ClusterActive, P2ClusterFreqMHz, P3ClusterActive, P3ClusterFreqMHz int
 }
 type NetDiskMetrics struct {
@@ -49,52 +46,9 @@ type MemoryMetrics struct {
 	Total, Used, Available, SwapTotal, SwapUsed uint64
 }
 
-func CollectMetrics(done chan struct{}, cpuMetricsChan chan CPUMetrics, gpuMetricsChan chan GPUMetrics, netDiskMetricsChan chan NetDiskMetrics, processMetricsChan chan []ProcessMetrics, modelName string, updateInterval int) {
-	var cpuMetrics CPUMetrics
-	var gpuMetrics GPUMetrics
-	var netDiskMetrics NetDiskMetrics
-	var processMetrics []ProcessMetrics
-	cmd := exec.Command("powermetrics", "--samplers", "cpu_power,gpu_power,thermal,network,disk", "--show-process-gpu", "--show-process-energy", "--show-initial-usage", "--show-process-netstats", "-i", strconv.Itoa(updateInterval))
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logrus.Fatalf("failed to get stdout pipe: %v", err)
-	}
-	if err := cmd.Start(); err != nil {
-		logrus.Fatalf("failed to start command: %v", err)
-	}
-	scanner := bufio.NewScanner(stdout)
-	go func() {
-		for {
-			select {
-			case <-done: // Check if we need to exit
-				cmd.Process.Kill() // Ensure subprocess is terminated
-				os.Exit(0)
-				return
-			default:
-				if scanner.Scan() {
-					line := scanner.Text()
-					cpuMetrics = parseCPUMetrics(line, cpuMetrics, modelName)
-					gpuMetrics = parseGPUMetrics(line, gpuMetrics)
-					netDiskMetrics = parseActivityMetrics(line, netDiskMetrics)
-					processMetrics = parseProcessMetrics(line, processMetrics)
-
-					cpuMetricsChan <- cpuMetrics
-					gpuMetricsChan <- gpuMetrics
-					netDiskMetricsChan <- netDiskMetrics
-					processMetricsChan <- processMetrics
-
-				} else {
-					if err := scanner.Err(); err != nil {
-						logrus.Printf("error during scan: %v", err)
-					}
-					return // Exit loop if Scan() returns false
-				}
-			}
-		}
-	}()
-	if err := cmd.Wait(); err != nil {
-		logrus.Fatalf("command failed: %v", err)
-	}
+type DiskSpaceMetrics struct {
+	Path              string
+	Total, Used, Free uint64
 }
 
 func parseProcessMetrics(powermetricsOutput string, processMetrics []ProcessMetrics) []ProcessMetrics {
@@ -367,6 +321,17 @@ func parseCPUMetrics(powermetricsOutput string, cpuMetrics CPUMetrics, modelName
 	return cpuMetrics
 }
 
+// ThermalPressureLevels lists the thermal pressure levels of macOS from
+// lowest to highest.
+var ThermalPressureLevels = []string{"Nominal", "Moderate", "Heavy", "Trapping", "Sleeping"}
+
+func parseThermalPressure(line, level string) string {
+	if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Current pressure level:"); ok {
+		return strings.TrimSpace(value)
+	}
+	return level
+}
+
 func parseGPUMetrics(powermetricsOutput string, gpuMetrics GPUMetrics) GPUMetrics {
 
 	lines := strings.Split(powermetricsOutput, "\n")
@@ -417,3 +382,17 @@ func GetMemoryMetrics() MemoryMetrics {
 		SwapUsed:  swapUsed,
 	}
 }
+
+func GetDiskSpaceMetrics(path string) (DiskSpaceMetrics, error) {
+	usage, err := disk.Usage(path)
+	if err != nil {
+		return DiskSpaceMetrics{}, err
+	}
+
+	return DiskSpaceMetrics{
+		Path:  path,
+		Total: usage.Total,
+		Used:  usage.Used,
+		Free:  usage.Free,
+	}, nil
+}
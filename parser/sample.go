@@ -0,0 +1,68 @@
+package parser
+
+import "strings"
+
+// sampleHeader is printed by powermetrics at the start of every sample.
+const sampleHeader = "*** Sampled system activity"
+
+// Sample holds the metrics parsed from a single powermetrics sample.
+type Sample struct {
+	CPU       CPUMetrics
+	GPU       GPUMetrics
+	NetDisk   NetDiskMetrics
+	Processes []ProcessMetrics
+	// ThermalPressure is the level reported by the thermal sampler, one
+	// of ThermalPressureLevels, or empty when it is off.
+	ThermalPressure string
+}
+
+// SampleParser turns powermetrics output, fed one line at a time, into
+// complete samples. CPU, GPU and network/disk values carry over between
+// samples the same way they did when every line was published, while the
+// process table starts empty for each sample.
+type SampleParser struct {
+	modelName string
+	sample    Sample
+	started   bool
+	pending   bool
+}
+
+func NewSampleParser(modelName string) *SampleParser {
+	return &SampleParser{modelName: modelName}
+}
+
+// ParseLine consumes one line of output. When the line starts a new sample
+// the previous one is returned together with true. The machine description
+// powermetrics prints before the first sample is skipped.
+func (p *SampleParser) ParseLine(line string) (Sample, bool) {
+	var done Sample
+	var ok bool
+	if strings.HasPrefix(strings.TrimSpace(line), sampleHeader) {
+		done, ok = p.Flush()
+		p.started = true
+	}
+	if !p.started {
+		return done, ok
+	}
+
+	p.sample.CPU = parseCPUMetrics(line, p.sample.CPU, p.modelName)
+	p.sample.GPU = parseGPUMetrics(line, p.sample.GPU)
+	p.sample.NetDisk = parseActivityMetrics(line, p.sample.NetDisk)
+	p.sample.Processes = parseProcessMetrics(line, p.sample.Processes)
+	p.sample.ThermalPressure = parseThermalPressure(line, p.sample.ThermalPressure)
+	p.pending = true
+
+	return done, ok
+}
+
+// Flush returns the sample collected so far, if any lines were parsed since
+// the last flush, and starts a new one.
+func (p *SampleParser) Flush() (Sample, bool) {
+	if !p.pending {
+		return Sample{}, false
+	}
+	sample := p.sample
+	p.sample.Processes = nil
+	p.pending = false
+	return sample, true
+}
@@ -0,0 +1,74 @@
+// Package privileges drops root back to the user who ran sudo.
+package privileges
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// SudoUser returns the user and group sudo was invoked by. ok is false
+// unless the process runs as root on behalf of another user.
+func SudoUser() (uid, gid int, ok bool) {
+	if os.Geteuid() != 0 {
+		return 0, 0, false
+	}
+	uid, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil || uid == 0 {
+		return 0, 0, false
+	}
+	gid, err = strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err != nil {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
+
+// SetUserEnv points HOME, USER and LOGNAME at uid, so paths resolved
+// before dropping root, such as the config file, are those of the user.
+func SetUserEnv(uid int) {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		setUserEnv(u)
+	}
+}
+
+func setUserEnv(u *user.User) {
+	os.Setenv("HOME", u.HomeDir)
+	os.Setenv("USER", u.Username)
+	os.Setenv("LOGNAME", u.Username)
+}
+
+// Drop permanently switches the process to uid and gid, with the
+// supplementary groups of that user. HOME, USER and LOGNAME are pointed at
+// the user as well, so files created afterwards end up in their home
+// directory and are owned by them.
+func Drop(uid, gid int) error {
+	groups := []int{gid}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if g, err := strconv.Atoi(id); err == nil && g != gid {
+					groups = append(groups, g)
+				}
+			}
+		}
+		setUserEnv(u)
+	}
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed to set groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set gid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set uid %d: %w", uid, err)
+	}
+	if syscall.Setuid(0) == nil {
+		return errors.New("root privileges could be regained after dropping them")
+	}
+	return nil
+}
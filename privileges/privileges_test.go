@@ -0,0 +1,64 @@
+package privileges
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDrop(t *testing.T) {
+	if os.Getenv("MACTOP_TEST_DROP") == "1" {
+		uid, gid, ok := SudoUser()
+		if !ok {
+			t.Fatal("expected a sudo user")
+		}
+		if err := Drop(uid, gid); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(os.Getenv("MACTOP_TEST_FILE"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges needs root")
+	}
+
+	// The dropped process has to reach a directory it may write to.
+	dir := t.TempDir()
+	if err := os.Chmod(filepath.Dir(dir), 0o711); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "created")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDrop$")
+	cmd.Env = append(os.Environ(), "MACTOP_TEST_DROP=1", "MACTOP_TEST_FILE="+file, "SUDO_UID=65534", "SUDO_GID=65534")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 65534 || stat.Gid != 65534 {
+		t.Errorf("file is owned by %d:%d, want 65534:65534", stat.Uid, stat.Gid)
+	}
+}
+
+func TestSudoUser(t *testing.T) {
+	t.Setenv("SUDO_UID", "")
+	if _, _, ok := SudoUser(); ok {
+		t.Error("expected no sudo user without SUDO_UID")
+	}
+	t.Setenv("SUDO_UID", "0")
+	t.Setenv("SUDO_GID", "0")
+	if _, _, ok := SudoUser(); ok {
+		t.Error("expected root invoking sudo not to count")
+	}
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Runner creates the commands mactop uses to talk to the system tools
+// (sysctl, system_profiler, powermetrics) so they can be swapped out in
+// tests.
+type Runner interface {
+	Command(name string, args ...string) *exec.Cmd
+}
+
+// Exec runs commands found on PATH.
+type Exec struct {
+	// Dir, when set, is searched for the executable before PATH.
+	Dir string
+}
+
+func (e Exec) Command(name string, args ...string) *exec.Cmd {
+	if e.Dir != "" {
+		path := filepath.Join(e.Dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return exec.Command(path, args...)
+		}
+	}
+	return exec.Command(name, args...)
+}
+
+// Default is the runner used when none is configured.
+var Default Runner = Exec{}
+
+// OrDefault returns r, or Default when r is nil.
+func OrDefault(r Runner) Runner {
+	if r == nil {
+		return Default
+	}
+	return r
+}
@@ -0,0 +1,150 @@
+package soc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/runner"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheFile is what CachedDetect stores. Key is a hash of the hardware UUID,
+// the OS build and the mactop version, so the file does not reveal the
+// UUID.
+type cacheFile struct {
+	Key     string   `json:"key"`
+	SocInfo *SocInfo `json:"soc_info"`
+}
+
+// SystemCachePath is where helpers running as root cache hardware info,
+// outside of any user's home.
+const SystemCachePath = "/Library/Caches/mactop/hardware.json"
+
+// HelperCachePath returns where a helper caches hardware info:
+// SystemCachePath on macOS, the user cache directory elsewhere.
+func HelperCachePath() string {
+	if runtime.GOOS == "darwin" {
+		return SystemCachePath
+	}
+	return DefaultCachePath()
+}
+
+// DefaultCachePath returns the cache file in the user cache directory, or
+// "" if there is none.
+func DefaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mactop", "hardware.json")
+}
+
+// CachedDetect returns the SocInfo stored at path when it was detected on
+// the same machine, OS build and mactop version. Otherwise, or when refresh
+// is set, it runs Detect and stores the result if detection was complete
+// but for the chip missing from the spec table, which is reported again on
+// every cache hit. Only sysctl and ioreg run on a cache hit, not
+// system_profiler.
+func CachedDetect(r runner.Runner, path, version string, refresh bool) (*SocInfo, error) {
+	key, err := cacheKey(r, version)
+	if err != nil {
+		logrus.Debugf("not caching hardware info: %v", err)
+		return Detect(r)
+	}
+	if !refresh {
+		if info, ok := readCache(path, key); ok {
+			return info, info.specWarning()
+		}
+	}
+
+	info, err := Detect(r)
+	if err != nil && !onlyMissingSpec(err) {
+		// Partial results are not worth keeping.
+		return info, err
+	}
+	if err := writeCache(path, cacheFile{Key: key, SocInfo: info}); err != nil {
+		logrus.Debugf("failed to cache hardware info: %v", err)
+	}
+	return info, err
+}
+
+// onlyMissingSpec reports whether every warning of Detect is about the
+// spec table.
+func onlyMissingSpec(err error) bool {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		if !errors.Is(e, errNoSpec) {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey identifies the hardware, the OS build and the mactop version.
+func cacheKey(r runner.Runner, version string) (string, error) {
+	build, err := r.Command("sysctl", "-n", "kern.osversion").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the OS build: %w", err)
+	}
+	out, err := r.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the hardware UUID: %w", err)
+	}
+	var uuid string
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, value, ok := strings.Cut(line, `"IOPlatformUUID" = `); ok {
+			uuid = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	if uuid == "" {
+		return "", errors.New("ioreg did not report IOPlatformUUID")
+	}
+	sum := sha256.Sum256([]byte(uuid + "\x00" + strings.TrimSpace(string(build)) + "\x00" + version))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readCache(path, key string) (*SocInfo, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Key != key || cache.SocInfo == nil {
+		return nil, false
+	}
+	return cache.SocInfo, true
+}
+
+// writeCache replaces the cache file atomically.
+func writeCache(path string, cache cacheFile) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hardware-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,103 @@
+package soc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/context-labs/mactop/v2/testharness"
+)
+
+// cacheBin fakes a Mac running the given OS build.
+func cacheBin(t *testing.T, build string) *testharness.Bin {
+	bin := testharness.NewBin(t)
+	bin.Script("sysctl", fmt.Sprintf(`if [ "$1" = -n ]; then echo %s; else cat %q; fi`, build, testharness.Fixture("sysctl_m1pro.txt")))
+	bin.Output("system_profiler", "system_profiler_m1pro.json")
+	bin.Script("ioreg", `echo '  | "IOPlatformUUID" = "00000000-1111-2222-3333-444444444444"'`)
+	return bin
+}
+
+func TestCachedDetect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mactop", "hardware.json")
+	bin := cacheBin(t, "23F79")
+
+	detect := func(bin *testharness.Bin, version string, refresh bool) {
+		t.Helper()
+		info, err := CachedDetect(bin.Runner(), path, version, refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != "Apple M1 Pro" || info.GpuCoreCount != 16 || info.MemoryBw != 200 {
+			t.Errorf("unexpected soc info %+v", info)
+		}
+	}
+	profiled := func(bin *testharness.Bin) int {
+		return len(bin.Calls("system_profiler"))
+	}
+
+	detect(bin, "v1", false)
+	detect(bin, "v1", false)
+	if n := profiled(bin); n != 1 {
+		t.Errorf("system_profiler ran %d times, want once", n)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "00000000-1111") {
+		t.Error("the cache must not store the hardware UUID")
+	}
+
+	detect(bin, "v1", true)
+	if n := profiled(bin); n != 2 {
+		t.Errorf("expected a refresh to detect again, system_profiler ran %d times", n)
+	}
+	detect(bin, "v2", false)
+	if n := profiled(bin); n != 3 {
+		t.Errorf("expected a new version to detect again, system_profiler ran %d times", n)
+	}
+
+	updated := cacheBin(t, "24A335")
+	detect(updated, "v2", false)
+	if n := profiled(updated); n != 1 {
+		t.Errorf("expected a new OS build to detect again, system_profiler ran %d times", n)
+	}
+}
+
+func TestCachedDetectSkipsPartialResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.json")
+	bin := cacheBin(t, "23F79")
+	bin.Script("system_profiler", "exit 1")
+	if _, err := CachedDetect(bin.Runner(), path, "v1", false); err == nil {
+		t.Fatal("expected a warning")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no cache file, got %v", err)
+	}
+}
+
+func TestCachedDetectUnknownChip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.json")
+	bin := cacheBin(t, "23F79")
+	rename := func(fixture string) string {
+		return fmt.Sprintf("sed 's/Apple M1 Pro/Apple M9 Pro/' %q", testharness.Fixture(fixture))
+	}
+	bin.Script("sysctl", fmt.Sprintf(`if [ "$1" = -n ]; then echo 23F79; else %s; fi`, rename("sysctl_m1pro.txt")))
+	bin.Script("system_profiler", rename("system_profiler_m1pro.json"))
+
+	for i := 0; i < 2; i++ {
+		info, err := CachedDetect(bin.Runner(), path, "v1", false)
+		if !errors.Is(err, errNoSpec) || !onlyMissingSpec(err) {
+			t.Errorf("expected only the missing spec warning, got %v", err)
+		}
+		if info.Name != "Apple M9 Pro" || info.GpuCoreCount != 16 {
+			t.Errorf("unexpected soc info %+v", info)
+		}
+	}
+	if n := len(bin.Calls("system_profiler")); n != 1 {
+		t.Errorf("system_profiler ran %d times, want once", n)
+	}
+}
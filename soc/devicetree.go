@@ -0,0 +1,161 @@
+package soc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/linux"
+)
+
+// chipNames maps the device tree name of every Apple Silicon chip Asahi
+// Linux supports to its marketing name.
+var chipNames = map[string]string{
+	"t8103": "Apple M1",
+	"t6000": "Apple M1 Pro",
+	"t6001": "Apple M1 Max",
+	"t6002": "Apple M1 Ultra",
+	"t8112": "Apple M2",
+	"t6020": "Apple M2 Pro",
+	"t6021": "Apple M2 Max",
+	"t6022": "Apple M2 Ultra",
+	"t8122": "Apple M3",
+	"t6030": "Apple M3 Pro",
+	"t6031": "Apple M3 Max",
+	"t6034": "Apple M3 Max",
+}
+
+// eCoreNames are the compatible strings of efficiency cores. Every other
+// apple core is a performance core.
+var eCoreNames = []string{"apple,icestorm", "apple,blizzard", "apple,sawtooth"}
+
+// errNotAppleSilicon is returned by DetectDeviceTree on other machines.
+var errNotAppleSilicon = errors.New("not an Apple Silicon device tree")
+
+// DetectDeviceTree describes an Apple Silicon machine running Linux from
+// the device tree under root/proc/device-tree and the SMC sensors under
+// root/sys/class/hwmon. root is "/" outside of tests.
+func DetectDeviceTree(root string) (*SocInfo, error) {
+	dt := filepath.Join(root, "proc", "device-tree")
+	compatible, err := readStrings(filepath.Join(dt, "compatible"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device tree: %w", err)
+	}
+	// The board comes first, then the chip: "apple,j314s", "apple,t6000",
+	// "apple,arm-platform".
+	var chip string
+	for _, c := range compatible {
+		if name, ok := strings.CutPrefix(c, "apple,"); ok && chipNames[name] != "" {
+			chip = name
+			break
+		}
+	}
+	if chip == "" {
+		return nil, errNotAppleSilicon
+	}
+
+	eCores, pCores, err := countCores(filepath.Join(dt, "cpus"))
+	if err != nil {
+		return nil, err
+	}
+	gpuCores, _ := gpuCoreCount(dt)
+	// The kernel keeps some memory to itself, so MemTotal is a little
+	// short of the installed size.
+	memory, _ := linux.FS{Root: root}.ReadMemory()
+
+	info := &SocInfo{
+		Name:         chipNames[chip],
+		Chip:         chip,
+		CoreCount:    eCores + pCores,
+		ECoreCount:   eCores,
+		PCoreCount:   pCores,
+		GpuCoreCount: gpuCores,
+		MemoryBytes:  memory.Total,
+		Sensors:      smcSensors(filepath.Join(root, "sys", "class", "hwmon")),
+	}
+	info.applySpec()
+	return info, nil
+}
+
+// countCores counts the efficiency and performance cores among the cpu@
+// nodes.
+func countCores(dir string) (eCores, pCores int, err error) {
+	nodes, err := filepath.Glob(filepath.Join(dir, "cpu@*"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, node := range nodes {
+		compatible, err := readStrings(filepath.Join(node, "compatible"))
+		if err != nil {
+			continue
+		}
+		switch {
+		case slices.ContainsFunc(compatible, func(c string) bool { return slices.Contains(eCoreNames, c) }):
+			eCores++
+		case len(compatible) > 0 && strings.HasPrefix(compatible[0], "apple,"):
+			pCores++
+		}
+	}
+	if eCores+pCores == 0 {
+		return 0, 0, fmt.Errorf("no cpu nodes in %s", dir)
+	}
+	return eCores, pCores, nil
+}
+
+// gpuCoreCount reads the number of GPU cores the bootloader stores in the
+// apple,core-count property of the GPU node.
+func gpuCoreCount(dt string) (int, bool) {
+	nodes, _ := filepath.Glob(filepath.Join(dt, "soc", "gpu@*"))
+	for _, node := range nodes {
+		data, err := os.ReadFile(filepath.Join(node, "apple,core-count"))
+		if err != nil || len(data) != 4 {
+			continue
+		}
+		return int(binary.BigEndian.Uint32(data)), true
+	}
+	return 0, false
+}
+
+// smcSensors lists the temperature sensors of the macsmc hwmon driver.
+func smcSensors(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var sensors []string
+	for _, e := range entries {
+		name, err := os.ReadFile(filepath.Join(dir, e.Name(), "name"))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(name)), "macsmc") {
+			continue
+		}
+		labels, _ := filepath.Glob(filepath.Join(dir, e.Name(), "temp*_label"))
+		for _, file := range labels {
+			label, err := os.ReadFile(file)
+			if err == nil {
+				sensors = append(sensors, strings.TrimSpace(string(label)))
+			}
+		}
+	}
+	sort.Strings(sensors)
+	return sensors
+}
+
+// readStrings reads a device tree property holding a list of
+// NUL-terminated strings.
+func readStrings(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, v := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+		values = append(values, string(v))
+	}
+	return values, nil
+}
@@ -0,0 +1,91 @@
+package soc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTree creates files below root, creating directories as needed.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// cpuNodes returns the cpu@ nodes of eCores efficiency and pCores
+// performance cores.
+func cpuNodes(eCore, pCore string, eCores, pCores int) map[string]string {
+	files := make(map[string]string)
+	for i := 0; i < eCores+pCores; i++ {
+		core := pCore
+		if i < eCores {
+			core = eCore
+		}
+		files[fmt.Sprintf("proc/device-tree/cpus/cpu@%d/compatible", i)] = core + "\x00arm,armv8\x00"
+	}
+	return files
+}
+
+func TestDetectDeviceTree(t *testing.T) {
+	root := t.TempDir()
+	files := cpuNodes("apple,icestorm", "apple,firestorm", 2, 8)
+	files["proc/device-tree/compatible"] = "apple,j314s\x00apple,t6000\x00apple,arm-platform\x00"
+	files["proc/device-tree/soc/gpu@6400000/apple,core-count"] = "\x00\x00\x00\x10"
+	files["sys/class/hwmon/hwmon0/name"] = "macsmc_hwmon\n"
+	files["sys/class/hwmon/hwmon0/temp1_label"] = "CPU Die\n"
+	files["sys/class/hwmon/hwmon0/temp2_label"] = "Battery\n"
+	files["sys/class/hwmon/hwmon1/name"] = "nvme\n"
+	files["sys/class/hwmon/hwmon1/temp1_label"] = "Composite\n"
+	writeTree(t, root, files)
+
+	info, err := DetectDeviceTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Apple M1 Pro" || info.Chip != "t6000" || info.CoreCount != 10 || info.ECoreCount != 2 || info.PCoreCount != 8 || info.GpuCoreCount != 16 {
+		t.Errorf("unexpected soc info %+v", info)
+	}
+	if !slices.Equal(info.Sensors, []string{"Battery", "CPU Die"}) {
+		t.Errorf("got sensors %v, want the SMC ones", info.Sensors)
+	}
+}
+
+func TestDetectDeviceTreeM2(t *testing.T) {
+	root := t.TempDir()
+	files := cpuNodes("apple,blizzard", "apple,avalanche", 4, 4)
+	files["proc/device-tree/compatible"] = "apple,j413\x00apple,t8112\x00apple,arm-platform\x00"
+	writeTree(t, root, files)
+
+	info, err := DetectDeviceTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without the GPU property the core count is unknown.
+	if info.Name != "Apple M2" || info.ECoreCount != 4 || info.PCoreCount != 4 || info.GpuCoreCount != 0 || info.Sensors != nil {
+		t.Errorf("unexpected soc info %+v", info)
+	}
+}
+
+func TestDetectDeviceTreeOtherMachines(t *testing.T) {
+	if _, err := DetectDeviceTree(t.TempDir()); err == nil {
+		t.Error("expected an error without a device tree")
+	}
+
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"proc/device-tree/compatible": "raspberrypi,4-model-b\x00brcm,bcm2711\x00",
+	})
+	if _, err := DetectDeviceTree(root); err != errNotAppleSilicon {
+		t.Errorf("got %v, want errNotAppleSilicon", err)
+	}
+}
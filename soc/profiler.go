@@ -0,0 +1,119 @@
+package soc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/context-labs/mactop/v2/runner"
+)
+
+// profilerDataTypes are the system_profiler reports Detect reads.
+var profilerDataTypes = []string{"SPDisplaysDataType", "SPHardwareDataType", "SPMemoryDataType"}
+
+// profilerReport is the output of system_profiler -json for
+// profilerDataTypes. Fields identifying the machine, such as the serial
+// number and UUIDs, are deliberately not decoded.
+type profilerReport struct {
+	Displays []profilerDisplay  `json:"SPDisplaysDataType"`
+	Hardware []profilerHardware `json:"SPHardwareDataType"`
+	Memory   []profilerMemory   `json:"SPMemoryDataType"`
+}
+
+type profilerDisplay struct {
+	Model       string `json:"sppci_model"`
+	DeviceType  string `json:"sppci_device_type"`
+	Cores       string `json:"sppci_cores"`
+	MetalFamily string `json:"spdisplays_mtlgpufamilysupport"`
+}
+
+type profilerHardware struct {
+	ChipType       string `json:"chip_type"`
+	MachineModel   string `json:"machine_model"`
+	MachineName    string `json:"machine_name"`
+	PhysicalMemory string `json:"physical_memory"`
+}
+
+type profilerMemory struct {
+	Size         string `json:"SPMemoryDataType"`
+	Type         string `json:"dimm_type"`
+	Manufacturer string `json:"dimm_manufacturer"`
+}
+
+// runProfiler runs system_profiler once for every data type Detect needs.
+func runProfiler(r runner.Runner) (*profilerReport, error) {
+	out, err := r.Command("system_profiler", append([]string{"-json"}, profilerDataTypes...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run system_profiler: %w", err)
+	}
+	return parseProfiler(out)
+}
+
+func parseProfiler(data []byte) (*profilerReport, error) {
+	var report profilerReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse system_profiler output: %w", err)
+	}
+	return &report, nil
+}
+
+// apply fills in what the report knows about the machine. Values already
+// known from sysctl are kept.
+func (report *profilerReport) apply(s *SocInfo) {
+	for _, d := range report.Displays {
+		if d.DeviceType != "spdisplays_gpu" {
+			continue
+		}
+		if cores, err := strconv.Atoi(d.Cores); err == nil {
+			s.GpuCoreCount = cores
+		}
+		s.MetalFamily = metalFamily(d.MetalFamily)
+		break
+	}
+	if len(report.Hardware) > 0 {
+		h := report.Hardware[0]
+		s.ModelIdentifier = h.MachineModel
+		s.MachineName = h.MachineName
+		if s.Name == "" {
+			s.Name = h.ChipType
+		}
+		if s.MemoryBytes == 0 {
+			s.MemoryBytes = parseSize(h.PhysicalMemory)
+		}
+	}
+	if len(report.Memory) > 0 {
+		m := report.Memory[0]
+		s.MemoryType = m.Type
+		if s.MemoryBytes == 0 {
+			s.MemoryBytes = parseSize(m.Size)
+		}
+	}
+}
+
+// metalFamily turns "spdisplays_metal3" into "Metal 3".
+func metalFamily(value string) string {
+	version, ok := strings.CutPrefix(value, "spdisplays_metal")
+	if !ok || version == "" {
+		return value
+	}
+	return "Metal " + version
+}
+
+// parseSize parses sizes such as "32 GB".
+func parseSize(value string) uint64 {
+	number, unit, _ := strings.Cut(strings.TrimSpace(value), " ")
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "TB":
+		return n << 40
+	case "GB":
+		return n << 30
+	case "MB":
+		return n << 20
+	}
+	return n
+}
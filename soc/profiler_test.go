@@ -0,0 +1,53 @@
+package soc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/context-labs/mactop/v2/testharness"
+)
+
+func TestParseProfiler(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		want    SocInfo
+	}{
+		{"system_profiler_m1.json", SocInfo{Name: "Apple M1", GpuCoreCount: 7, MemoryBytes: 8 << 30, MemoryType: "LPDDR4", MetalFamily: "Metal 3", ModelIdentifier: "MacBookAir10,1", MachineName: "MacBook Air"}},
+		{"system_profiler_m1pro.json", SocInfo{Name: "Apple M1 Pro", GpuCoreCount: 16, MemoryBytes: 32 << 30, MemoryType: "LPDDR5", MetalFamily: "Metal 3", ModelIdentifier: "MacBookPro18,3", MachineName: "MacBook Pro"}},
+		{"system_profiler_m2.json", SocInfo{Name: "Apple M2", GpuCoreCount: 10, MemoryBytes: 16 << 30, MemoryType: "LPDDR5", MetalFamily: "Metal 3", ModelIdentifier: "Mac14,2", MachineName: "MacBook Air"}},
+		{"system_profiler_m3max.json", SocInfo{Name: "Apple M3 Max", GpuCoreCount: 40, MemoryBytes: 48 << 30, MemoryType: "LPDDR5", MetalFamily: "Metal 3", ModelIdentifier: "Mac15,9", MachineName: "MacBook Pro"}},
+	} {
+		data, err := os.ReadFile(testharness.Fixture(tc.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := parseProfiler(data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.fixture, err)
+			continue
+		}
+		var got SocInfo
+		report.apply(&got)
+		if got.Name != tc.want.Name || got.GpuCoreCount != tc.want.GpuCoreCount || got.MemoryBytes != tc.want.MemoryBytes ||
+			got.MemoryType != tc.want.MemoryType || got.MetalFamily != tc.want.MetalFamily ||
+			got.ModelIdentifier != tc.want.ModelIdentifier || got.MachineName != tc.want.MachineName {
+			t.Errorf("%s: got %+v, want %+v", tc.fixture, got, tc.want)
+		}
+	}
+}
+
+func TestParseProfilerErrors(t *testing.T) {
+	if _, err := parseProfiler([]byte("Graphics/Displays:\n")); err == nil {
+		t.Error("expected an error for text output")
+	}
+	// sysctl wins over system_profiler for the memory size.
+	report, err := parseProfiler([]byte(`{"SPHardwareDataType": [{"physical_memory": "16 GB"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := SocInfo{MemoryBytes: 24 << 30}
+	report.apply(&info)
+	if info.MemoryBytes != 24<<30 || info.GpuCoreCount != 0 {
+		t.Errorf("unexpected soc info %+v", info)
+	}
+}
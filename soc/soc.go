@@ -3,103 +3,145 @@ package soc
 import (
 	"bufio"
 	"bytes"
-	"github.com/sirupsen/logrus"
-	"os/exec"
+	"errors"
+	"fmt"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/context-labs/mactop/v2/runner"
 )
 
 type SocInfo struct {
-	Name         string
-	CoreCount    string
-	CpuMaxPower  string
-	GpuMaxPower  string
-	CpuMaxBw     string
-	GpuMaxBw     string
-	ECoreCount   int
-	PCoreCount   int
-	GpuCoreCount string
-}
+	Name       string
+	CoreCount  int
+	ECoreCount int
+	PCoreCount int
+	// GpuCoreCount is zero when it could not be detected.
+	GpuCoreCount int
+	// MemoryBytes is the size of the unified memory.
+	MemoryBytes uint64
+	// MemoryType is the kind of memory, such as LPDDR5.
+	MemoryType string `json:",omitempty"`
+	// MetalFamily is the Metal version the GPU supports, such as Metal 3.
+	MetalFamily string `json:",omitempty"`
+	// ModelIdentifier and MachineName describe the Mac, such as
+	// MacBookPro18,3 and MacBook Pro.
+	ModelIdentifier string `json:",omitempty"`
+	MachineName     string `json:",omitempty"`
 
-var socInfo *SocInfo
-
-func GetSOCInfo() *SocInfo {
-	// cache the result won't change after launch
-	if socInfo != nil {
-		return socInfo
-	}
+	// The limits below come from the spec table and are zero for unknown
+	// chips. Power is in watts and bandwidth in GB/s.
+	CpuMaxPower float64
+	GpuMaxPower float64
+	AneMaxPower float64
+	CpuMaxBw    float64
+	GpuMaxBw    float64
+	MemoryBw    float64
+	MaxEFreqMHz int
+	MaxPFreqMHz int
+	AneTOPS     float64
 
-	sync.OnceFunc(func() {
-		m := getSysCtlProperties("machdep.cpu", "hw.perflevel0.logicalcpu", "hw.perflevel1.logicalcpu")
+	// Chip is the device tree name of the chip, such as t6000. Only set
+	// on Linux.
+	Chip string `json:",omitempty"`
+	// Sensors lists the temperature sensors of the SMC. Only set on Linux.
+	Sensors []string `json:",omitempty"`
+}
 
-		name := m["machdep.cpu.brand_string"]
-		coreCount := m["machdep.cpu.core_count"]
-		eCoreCount, err := strconv.Atoi(m["hw.perflevel1.logicalcpu"])
-		if err != nil {
-			logrus.Fatalf("failed to parse hw.perflevel1.logicalcpu, err: %v", err)
-		}
-		pCoreCount, err := strconv.Atoi(m["hw.perflevel0.logicalcpu"])
+// detectOnce detects the machine the first time GetSOCInfo is called.
+var detectOnce = sync.OnceValues(func() (*SocInfo, error) {
+	if runtime.GOOS == "linux" {
+		info, err := DetectDeviceTree("/")
 		if err != nil {
-			logrus.Errorf("failed to parse hw.perflevel0.logicalcpu, err: %v", err)
+			return &SocInfo{}, err
 		}
+		return info, nil
+	}
+	return Detect(runner.Default)
+})
 
-		socInfo = &SocInfo{
-			Name:         name,
-			CoreCount:    coreCount,
-			CpuMaxPower:  "",
-			GpuMaxPower:  "",
-			CpuMaxBw:     "",
-			GpuMaxBw:     "",
-			ECoreCount:   eCoreCount,
-			PCoreCount:   pCoreCount,
-			GpuCoreCount: getGPUCores(),
-		}
-	})()
-
-	return socInfo
+// GetSOCInfo detects the machine once and returns the same result on every
+// call. See Detect for how to treat the error.
+func GetSOCInfo() (*SocInfo, error) {
+	return detectOnce()
 }
 
-func getSysCtlProperties(properties ...string) map[string]string {
-	var rs = make(map[string]string)
-	out, err := exec.Command("sysctl", properties...).Output()
+// Detect queries sysctl and system_profiler through r without caching. The
+// returned SocInfo is never nil: whatever could not be detected is left
+// empty and every problem is listed in the error, so callers can decide
+// whether to carry on with partial information.
+func Detect(r runner.Runner) (*SocInfo, error) {
+	var warnings []error
+	m, err := getSysCtlProperties(r, "machdep.cpu", "hw.perflevel0.logicalcpu", "hw.perflevel1.logicalcpu", "hw.memsize")
 	if err != nil {
-		logrus.Fatalf("fail to execute getSysCtlProperties() sysctl command: %v", err)
+		warnings = append(warnings, err)
+	}
+	parse := func(key string) int {
+		value, ok := m[key]
+		if !ok {
+			if err == nil {
+				warnings = append(warnings, fmt.Errorf("sysctl did not report %s", key))
+			}
+			return 0
+		}
+		n, parseErr := strconv.Atoi(value)
+		if parseErr != nil {
+			warnings = append(warnings, fmt.Errorf("failed to parse %s: %w", key, parseErr))
+		}
+		return n
 	}
 
-	buf := bytes.NewReader(out)
-	scanner := bufio.NewScanner(buf)
+	info := &SocInfo{
+		Name:       m["machdep.cpu.brand_string"],
+		CoreCount:  parse("machdep.cpu.core_count"),
+		ECoreCount: parse("hw.perflevel1.logicalcpu"),
+		PCoreCount: parse("hw.perflevel0.logicalcpu"),
+	}
+	info.MemoryBytes = uint64(max(parse("hw.memsize"), 0))
 
-	var text string
-	for scanner.Scan() {
-		text = scanner.Text()
-		parts := strings.Split(text, ":")
-		if len(parts) != 2 {
-			logrus.Fatalf("fail to split properties: %s", text)
-		}
-		rs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	report, err := runProfiler(r)
+	if err != nil {
+		warnings = append(warnings, err)
+	} else {
+		report.apply(info)
+	}
+	if err := info.specWarning(); err != nil {
+		warnings = append(warnings, err)
 	}
+	return info, errors.Join(warnings...)
+}
 
-	return rs
+// errNoSpec marks a chip missing from the spec table. Everything else about
+// the machine was still detected.
+var errNoSpec = errors.New("limits are unknown")
+
+// specWarning applies the spec table to s, or reports that the chip is not
+// in it.
+func (s *SocInfo) specWarning() error {
+	if s.applySpec() {
+		return nil
+	}
+	return fmt.Errorf("no specifications for %q, %w", s.Name, errNoSpec)
 }
 
-func getGPUCores() string {
-	cmd, err := exec.Command("system_profiler", "-detailLevel", "basic", "SPDisplaysDataType").Output()
+// getSysCtlProperties returns the values of the given sysctl properties.
+// Lines it cannot parse are skipped.
+func getSysCtlProperties(r runner.Runner, properties ...string) (map[string]string, error) {
+	var rs = make(map[string]string)
+	out, err := r.Command("sysctl", properties...).Output()
 	if err != nil {
-		logrus.Fatalf("failed to execute system_profiler command: %v", err)
+		return rs, fmt.Errorf("failed to run sysctl: %w", err)
 	}
-	output := string(cmd)
-	logrus.Printf("Output: %s\n", output)
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Total Number of Cores") {
-			parts := strings.Split(line, ": ")
-			if len(parts) > 1 {
-				cores := strings.TrimSpace(parts[1])
-				return cores
-			}
-			break
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
 		}
+		rs[strings.TrimSpace(key)] = strings.TrimSpace(value)
 	}
-	return "?"
+	return rs, nil
 }
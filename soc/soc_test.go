@@ -1,8 +1,89 @@
 package soc
 
-import "testing"
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/context-labs/mactop/v2/testharness"
+)
 
 func TestGetSOCInfo(t *testing.T) {
-	soc1 := GetSOCInfo()
+	if runtime.GOOS != "darwin" {
+		t.Skip("needs sysctl and system_profiler from macOS")
+	}
+	soc1, err := GetSOCInfo()
+	if err != nil {
+		t.Log(err)
+	}
+	if soc2, _ := GetSOCInfo(); soc2 != soc1 {
+		t.Error("expected the cached result")
+	}
 	t.Log(soc1)
 }
+
+func TestDetect(t *testing.T) {
+	bin := testharness.NewBin(t)
+	bin.Output("sysctl", "sysctl_m1pro.txt")
+	bin.Output("system_profiler", "system_profiler_m1pro.json")
+
+	info, err := Detect(bin.Runner())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Name != "Apple M1 Pro" || info.CoreCount != 10 || info.ECoreCount != 2 || info.PCoreCount != 8 || info.GpuCoreCount != 16 {
+		t.Errorf("unexpected soc info %+v", info)
+	}
+	if info.MemoryBytes != 32<<30 || info.MemoryBw != 200 || info.MaxPFreqMHz != 3228 || info.AneTOPS != 11 {
+		t.Errorf("expected memory and the M1 Pro spec, got %+v", info)
+	}
+}
+
+func TestDetectPartial(t *testing.T) {
+	bin := testharness.NewBin(t)
+	// An unknown chip without performance levels and a line that does not
+	// parse.
+	bin.Script("sysctl", "printf 'machdep.cpu.core_count: 8\\nmachdep.cpu.brand_string: Apple M9\\ngarbage\\n'")
+	bin.Script("system_profiler", "exit 1")
+
+	info, err := Detect(bin.Runner())
+	if err == nil {
+		t.Fatal("expected warnings")
+	}
+	for _, want := range []string{"hw.perflevel1.logicalcpu", "system_profiler", "Apple M9"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected a warning about %s, got %v", want, err)
+		}
+	}
+	if info.Name != "Apple M9" || info.CoreCount != 8 || info.ECoreCount != 0 || info.CpuMaxPower != 0 {
+		t.Errorf("unexpected partial soc info %+v", info)
+	}
+
+	bin.Script("sysctl", "exit 1")
+	if info, err := Detect(bin.Runner()); err == nil || info == nil {
+		t.Errorf("expected an empty soc info and an error, got %+v, %v", info, err)
+	}
+}
+
+func TestLookupSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		eCores, pCores int
+		cpuMaxPower    float64
+		memoryBw       float64
+	}{
+		{"Apple M1 Pro", 2, 6, 24, 200},
+		{"Apple M1 Pro", 2, 8, 30, 200},
+		{"Apple M3 Max", 4, 10, 45, 300},
+		{"Apple M3 Max", 4, 12, 55, 400},
+	} {
+		spec, ok := LookupSpec(tc.name, tc.eCores, tc.pCores, 0)
+		if !ok || spec.CpuMaxPower != tc.cpuMaxPower || spec.MemoryBw != tc.memoryBw {
+			t.Errorf("%s %dE+%dP: got %+v", tc.name, tc.eCores, tc.pCores, spec)
+		}
+	}
+	if _, ok := LookupSpec("Apple M9", 4, 4, 8); ok {
+		t.Error("expected no spec for an unknown chip")
+	}
+}
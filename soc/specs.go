@@ -0,0 +1,80 @@
+package soc
+
+// Spec holds the limits of one chip configuration, from Apple's published
+// specifications and, for power and per-cluster bandwidth, from public
+// measurements. Power is in watts and bandwidth in GB/s.
+type Spec struct {
+	Name string
+	// ECores, PCores and GPUCores select a configuration of the chip.
+	// Zero matches any count.
+	ECores, PCores, GPUCores int
+
+	CpuMaxPower float64
+	GpuMaxPower float64
+	AneMaxPower float64
+	// CpuMaxBw and GpuMaxBw are the memory bandwidth the CPU and GPU
+	// clusters can draw on their own. MemoryBw is the bandwidth of the
+	// memory interface.
+	CpuMaxBw    float64
+	GpuMaxBw    float64
+	MemoryBw    float64
+	MaxEFreqMHz int
+	MaxPFreqMHz int
+	AneTOPS     float64
+}
+
+// Specs lists every known chip configuration. More specific entries of a
+// chip come before the generic one.
+var Specs = []Spec{
+	{Name: "Apple M1", CpuMaxPower: 20, GpuMaxPower: 12, AneMaxPower: 8, CpuMaxBw: 60, GpuMaxBw: 60, MemoryBw: 68.25, MaxEFreqMHz: 2064, MaxPFreqMHz: 3204, AneTOPS: 11},
+	{Name: "Apple M1 Pro", PCores: 6, CpuMaxPower: 24, GpuMaxPower: 20, AneMaxPower: 8, CpuMaxBw: 200, GpuMaxBw: 200, MemoryBw: 200, MaxEFreqMHz: 2064, MaxPFreqMHz: 3228, AneTOPS: 11},
+	{Name: "Apple M1 Pro", CpuMaxPower: 30, GpuMaxPower: 25, AneMaxPower: 8, CpuMaxBw: 200, GpuMaxBw: 200, MemoryBw: 200, MaxEFreqMHz: 2064, MaxPFreqMHz: 3228, AneTOPS: 11},
+	{Name: "Apple M1 Max", CpuMaxPower: 30, GpuMaxPower: 60, AneMaxPower: 8, CpuMaxBw: 243, GpuMaxBw: 400, MemoryBw: 400, MaxEFreqMHz: 2064, MaxPFreqMHz: 3228, AneTOPS: 11},
+	{Name: "Apple M1 Ultra", CpuMaxPower: 60, GpuMaxPower: 120, AneMaxPower: 16, CpuMaxBw: 486, GpuMaxBw: 800, MemoryBw: 800, MaxEFreqMHz: 2064, MaxPFreqMHz: 3228, AneTOPS: 22},
+	{Name: "Apple M2", CpuMaxPower: 20, GpuMaxPower: 15, AneMaxPower: 8, CpuMaxBw: 100, GpuMaxBw: 100, MemoryBw: 100, MaxEFreqMHz: 2424, MaxPFreqMHz: 3504, AneTOPS: 15.8},
+	{Name: "Apple M2 Pro", CpuMaxPower: 35, GpuMaxPower: 30, AneMaxPower: 8, CpuMaxBw: 200, GpuMaxBw: 200, MemoryBw: 200, MaxEFreqMHz: 2424, MaxPFreqMHz: 3504, AneTOPS: 15.8},
+	{Name: "Apple M2 Max", CpuMaxPower: 35, GpuMaxPower: 60, AneMaxPower: 8, CpuMaxBw: 240, GpuMaxBw: 400, MemoryBw: 400, MaxEFreqMHz: 2424, MaxPFreqMHz: 3696, AneTOPS: 15.8},
+	{Name: "Apple M2 Ultra", CpuMaxPower: 70, GpuMaxPower: 120, AneMaxPower: 16, CpuMaxBw: 480, GpuMaxBw: 800, MemoryBw: 800, MaxEFreqMHz: 2424, MaxPFreqMHz: 3696, AneTOPS: 31.6},
+	{Name: "Apple M3", CpuMaxPower: 20, GpuMaxPower: 20, AneMaxPower: 8, CpuMaxBw: 100, GpuMaxBw: 100, MemoryBw: 100, MaxEFreqMHz: 2748, MaxPFreqMHz: 4056, AneTOPS: 18},
+	{Name: "Apple M3 Pro", CpuMaxPower: 30, GpuMaxPower: 30, AneMaxPower: 8, CpuMaxBw: 150, GpuMaxBw: 150, MemoryBw: 150, MaxEFreqMHz: 2748, MaxPFreqMHz: 4056, AneTOPS: 18},
+	{Name: "Apple M3 Max", PCores: 10, CpuMaxPower: 45, GpuMaxPower: 50, AneMaxPower: 8, CpuMaxBw: 300, GpuMaxBw: 300, MemoryBw: 300, MaxEFreqMHz: 2748, MaxPFreqMHz: 4056, AneTOPS: 18},
+	{Name: "Apple M3 Max", CpuMaxPower: 55, GpuMaxPower: 60, AneMaxPower: 8, CpuMaxBw: 400, GpuMaxBw: 400, MemoryBw: 400, MaxEFreqMHz: 2748, MaxPFreqMHz: 4056, AneTOPS: 18},
+	{Name: "Apple M3 Ultra", CpuMaxPower: 110, GpuMaxPower: 140, AneMaxPower: 16, CpuMaxBw: 800, GpuMaxBw: 819, MemoryBw: 819, MaxEFreqMHz: 2748, MaxPFreqMHz: 4056, AneTOPS: 36},
+	{Name: "Apple M4", CpuMaxPower: 25, GpuMaxPower: 20, AneMaxPower: 8, CpuMaxBw: 120, GpuMaxBw: 120, MemoryBw: 120, MaxEFreqMHz: 2892, MaxPFreqMHz: 4512, AneTOPS: 38},
+	{Name: "Apple M4 Pro", CpuMaxPower: 40, GpuMaxPower: 35, AneMaxPower: 8, CpuMaxBw: 273, GpuMaxBw: 273, MemoryBw: 273, MaxEFreqMHz: 2892, MaxPFreqMHz: 4512, AneTOPS: 38},
+	{Name: "Apple M4 Max", PCores: 10, CpuMaxPower: 55, GpuMaxPower: 55, AneMaxPower: 8, CpuMaxBw: 410, GpuMaxBw: 410, MemoryBw: 410, MaxEFreqMHz: 2892, MaxPFreqMHz: 4512, AneTOPS: 38},
+	{Name: "Apple M4 Max", CpuMaxPower: 65, GpuMaxPower: 70, AneMaxPower: 8, CpuMaxBw: 546, GpuMaxBw: 546, MemoryBw: 546, MaxEFreqMHz: 2892, MaxPFreqMHz: 4512, AneTOPS: 38},
+}
+
+// LookupSpec returns the first spec matching the chip name and core
+// configuration.
+func LookupSpec(name string, eCores, pCores, gpuCores int) (Spec, bool) {
+	matches := func(want, got int) bool {
+		return want == 0 || want == got
+	}
+	for _, s := range Specs {
+		if s.Name == name && matches(s.ECores, eCores) && matches(s.PCores, pCores) && matches(s.GPUCores, gpuCores) {
+			return s, true
+		}
+	}
+	return Spec{}, false
+}
+
+// applySpec fills in the limits of the chip and reports whether it is
+// known.
+func (s *SocInfo) applySpec() bool {
+	spec, ok := LookupSpec(s.Name, s.ECoreCount, s.PCoreCount, s.GpuCoreCount)
+	if !ok {
+		return false
+	}
+	s.CpuMaxPower = spec.CpuMaxPower
+	s.GpuMaxPower = spec.GpuMaxPower
+	s.AneMaxPower = spec.AneMaxPower
+	s.CpuMaxBw = spec.CpuMaxBw
+	s.GpuMaxBw = spec.GpuMaxBw
+	s.MemoryBw = spec.MemoryBw
+	s.MaxEFreqMHz = spec.MaxEFreqMHz
+	s.MaxPFreqMHz = spec.MaxPFreqMHz
+	s.AneTOPS = spec.AneTOPS
+	return true
+}
@@ -0,0 +1,123 @@
+// Package testharness installs scripted fake versions of sysctl,
+// system_profiler and powermetrics so code that shells out to them can be
+// tested on any machine.
+package testharness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/context-labs/mactop/v2/runner"
+)
+
+// Bin is a directory of fake executables.
+type Bin struct {
+	Dir string
+	t   testing.TB
+}
+
+func NewBin(t testing.TB) *Bin {
+	t.Helper()
+	return &Bin{Dir: t.TempDir(), t: t}
+}
+
+// Runner returns a runner that prefers the fake executables over PATH.
+func (b *Bin) Runner() runner.Runner {
+	return runner.Exec{Dir: b.Dir}
+}
+
+// Script installs name as a shell script running body. Every invocation
+// records its arguments and process id, see Calls and Pids.
+func (b *Bin) Script(name, body string) {
+	b.t.Helper()
+	script := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %q\necho $$ >> %q\n%s\n",
+		b.path(name+".calls"), b.path(name+".pids"), body)
+	if err := os.WriteFile(b.path(name), []byte(script), 0o755); err != nil {
+		b.t.Fatalf("failed to install fake %s: %v", name, err)
+	}
+}
+
+// Output installs name so that it prints fixture and exits successfully.
+func (b *Bin) Output(name, fixture string) {
+	b.t.Helper()
+	b.Script(name, fmt.Sprintf("cat %q", Fixture(fixture)))
+}
+
+// Replay describes a fake long running sampler such as powermetrics.
+type Replay struct {
+	// Fixture is printed once per sample.
+	Fixture string
+	// Count is the number of samples printed before exiting. Zero prints
+	// samples until killed.
+	Count    int
+	Interval time.Duration
+	ExitCode int
+}
+
+// Replay installs name so that it prints r.Fixture every r.Interval.
+func (b *Bin) Replay(name string, r Replay) {
+	b.t.Helper()
+	interval := strconv.FormatFloat(r.Interval.Seconds(), 'f', 3, 64)
+	loop := "while true"
+	if r.Count > 0 {
+		loop = fmt.Sprintf("i=0\nwhile [ $i -lt %d ]", r.Count)
+	}
+	b.Script(name, fmt.Sprintf("%s; do\n  cat %q\n  i=$((i+1))\n  sleep %s\ndone\nexit %d",
+		loop, Fixture(r.Fixture), interval, r.ExitCode))
+}
+
+// Calls returns the arguments of every invocation of name so far.
+func (b *Bin) Calls(name string) []string {
+	return b.lines(name + ".calls")
+}
+
+// Pids returns the process ids of every invocation of name so far.
+func (b *Bin) Pids(name string) []int {
+	var pids []int
+	for _, line := range b.lines(name + ".pids") {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			b.t.Fatalf("bad pid %q for %s", line, name)
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+func (b *Bin) lines(file string) []string {
+	data, err := os.ReadFile(b.path(file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		b.t.Fatalf("failed to read %s: %v", file, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func (b *Bin) path(name string) string {
+	return filepath.Join(b.Dir, name)
+}
+
+// MacBookPro installs fakes for every tool mactop uses, replaying the
+// output of an M1 Pro. powermetrics prints samples every interval until it
+// is killed.
+func MacBookPro(t testing.TB, interval time.Duration) *Bin {
+	b := NewBin(t)
+	b.Output("sysctl", "sysctl_m1pro.txt")
+	b.Output("system_profiler", "system_profiler_m1pro.json")
+	b.Replay("powermetrics", Replay{Fixture: "powermetrics_m1pro.txt", Interval: interval})
+	return b
+}
+
+// Fixture returns the path of a file in the harness testdata directory.
+func Fixture(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", name)
+}
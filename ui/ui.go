@@ -2,6 +2,8 @@ package ui
 
 import (
 	"fmt"
+	"github.com/context-labs/mactop/v2/bus"
+	"github.com/context-labs/mactop/v2/collector"
 	"github.com/context-labs/mactop/v2/event_throttler"
 	"github.com/context-labs/mactop/v2/parser"
 	"github.com/context-labs/mactop/v2/soc"
@@ -10,7 +12,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"math"
 	"os"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,118 +30,219 @@ type UI struct {
 	socInfo           *soc.SocInfo
 	colorName         string
 	currentGridLayout GridLayout
-	lastUpdateTime    time.Time
-	updateInterval    int
+	collectorOpts     collector.Options
+	hidden            map[termui.Drawable]bool
 
-	done chan struct{}
 	quit <-chan os.Signal
 
-	cpuMetricsChan     chan parser.CPUMetrics
-	gpuMetricsChan     chan parser.GPUMetrics
-	netDiskMetricsChan chan parser.NetDiskMetrics
-	processMetricsChan chan []parser.ProcessMetrics
+	snapshots  *bus.Subscription[collector.Snapshot]
+	health     *bus.Subscription[collector.Health]
+	controller collector.Controller
+	history    *collector.History
+	needRender *event_throttler.EventThrottler
 
 	grid                                            *termui.Grid
 	cpu1Gauge, cpu2Gauge, gpuGauge, aneGauge        *widgets.Gauge
 	TotalPowerChart                                 *widgets.BarChart
 	memoryGauge                                     *widgets.Gauge
 	modelText, PowerChart, NetworkInfo, ProcessInfo *widgets.Paragraph
+	DerivedInfo                                     *widgets.Paragraph
 
-	powerValues []float64
+	modelInfo  string
+	lastHealth collector.Health
+	interval   int
 }
 
+// staleSuffix is appended to the titles of widgets whose source stopped
+// producing samples.
+const staleSuffix = " (stale)"
+
+// staleColor greys out stale widgets.
+var staleColor = termui.Color(8)
+
+// intervalSteps are the sample intervals, in milliseconds, the + and - keys
+// step through.
+var intervalSteps = []int{100, 250, 500, 1000, 2000, 5000, 10000}
+
 func NewUI(colorName string,
-	updateInterval int,
+	collectorOpts collector.Options,
 	socInfo *soc.SocInfo,
-	done chan struct{},
 	quit <-chan os.Signal,
-	cpuMetricsChan chan parser.CPUMetrics,
-	gpuMetricsChan chan parser.GPUMetrics,
-	netDiskMetricsChan chan parser.NetDiskMetrics,
-	processMetricsChan chan []parser.ProcessMetrics,
+	snapshots *bus.Subscription[collector.Snapshot],
+	health *bus.Subscription[collector.Health],
+	controller collector.Controller,
+	history *collector.History,
 ) *UI {
 	var ui = &UI{}
 	ui.colorName = colorName
-	ui.updateInterval = updateInterval
+	ui.collectorOpts = collectorOpts
 
 	ui.socInfo = socInfo
 
-	ui.done = done
 	ui.quit = quit
 
-	ui.cpuMetricsChan = cpuMetricsChan
-	ui.gpuMetricsChan = gpuMetricsChan
-	ui.netDiskMetricsChan = netDiskMetricsChan
-	ui.processMetricsChan = processMetricsChan
+	ui.snapshots = snapshots
+	ui.health = health
+	ui.controller = controller
+	ui.history = history
+	ui.interval = collectorOpts.Interval
 
 	return ui
 }
 
-func (ui *UI) setupGrid() {
-	ui.grid = termui.NewGrid()
-	ui.grid.Set(
-		termui.NewRow(1.0/2, // This row now takes half the height of the grid
-			termui.NewCol(1.0/2, termui.NewRow(1.0/2, ui.cpu1Gauge), termui.NewCol(1.0, termui.NewRow(1.0, ui.cpu2Gauge))),
-			termui.NewCol(1.0/2, termui.NewRow(1.0/2, ui.gpuGauge), termui.NewCol(1.0, termui.NewRow(1.0, ui.aneGauge))), // termui.NewCol(1.0/2, termui.NewRow(1.0, ProcessInfo)), // ProcessInfo spans this entire column
-		),
-		termui.NewRow(1.0/4,
-			termui.NewCol(1.0/6, ui.modelText),
-			termui.NewCol(1.0/3, ui.NetworkInfo),
-			termui.NewCol(1.0/4, ui.PowerChart),
-			termui.NewCol(1.0/4, ui.TotalPowerChart),
-		),
-		termui.NewRow(1.0/4,
-			termui.NewCol(1.0, ui.memoryGauge),
-		),
-	)
+// layoutCol is a column of widgets stacked on top of each other.
+type layoutCol struct {
+	ratio   float64
+	widgets []termui.Drawable
 }
 
-func (ui *UI) switchGridLayout() {
-	if ui.currentGridLayout == DefaultGridLayout {
-		newGrid := termui.NewGrid()
-		newGrid.Set(
-			termui.NewRow(1.0/2, // This row now takes half the height of the grid
-				termui.NewCol(1.0/2, termui.NewRow(1.0, ui.cpu1Gauge)), // termui.NewCol(1.0, termui.NewRow(1.0, cpu2Gauge))),
-				termui.NewCol(1.0/2, termui.NewRow(1.0, ui.cpu2Gauge)), // ProcessInfo spans this entire column
+type layoutRow struct {
+	ratio float64
+	cols  []layoutCol
+}
+
+func col(ratio float64, widgets ...termui.Drawable) layoutCol {
+	return layoutCol{ratio: ratio, widgets: widgets}
+}
+
+func row(ratio float64, cols ...layoutCol) layoutRow {
+	return layoutRow{ratio: ratio, cols: cols}
+}
+
+// buildGrid turns rows into a grid, leaving out hidden widgets and giving
+// their space to the remaining ones.
+func (ui *UI) buildGrid(rows ...layoutRow) *termui.Grid {
+	var visibleRows []layoutRow
+	var rowTotal float64
+	for _, r := range rows {
+		var cols []layoutCol
+		var colTotal float64
+		for _, c := range r.cols {
+			var widgets []termui.Drawable
+			for _, w := range c.widgets {
+				if !ui.hidden[w] {
+					widgets = append(widgets, w)
+				}
+			}
+			if len(widgets) > 0 {
+				cols = append(cols, layoutCol{ratio: c.ratio, widgets: widgets})
+				colTotal += c.ratio
+			}
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		for i := range cols {
+			cols[i].ratio /= colTotal
+		}
+		visibleRows = append(visibleRows, layoutRow{ratio: r.ratio, cols: cols})
+		rowTotal += r.ratio
+	}
+
+	var gridRows []interface{}
+	for _, r := range visibleRows {
+		var gridCols []interface{}
+		for _, c := range r.cols {
+			var items []interface{}
+			for _, w := range c.widgets {
+				items = append(items, termui.NewRow(1.0/float64(len(c.widgets)), w))
+			}
+			gridCols = append(gridCols, termui.NewCol(c.ratio, items...))
+		}
+		gridRows = append(gridRows, termui.NewRow(r.ratio/rowTotal, gridCols...))
+	}
+
+	grid := termui.NewGrid()
+	grid.Set(gridRows...)
+	termWidth, termHeight := termui.TerminalDimensions()
+	grid.SetRect(0, 0, termWidth, termHeight)
+	return grid
+}
+
+func (ui *UI) setupGrid() {
+	switch ui.currentGridLayout {
+	case AlternativeGridLayout:
+		ui.grid = ui.buildGrid(
+			row(1.0/2,
+				col(1.0/2, ui.cpu1Gauge),
+				col(1.0/2, ui.cpu2Gauge),
 			),
-			termui.NewRow(1.0/4,
-				termui.NewCol(1.0/4, ui.gpuGauge),
-				termui.NewCol(1.0/4, ui.aneGauge),
-				termui.NewCol(1.0/4, ui.PowerChart),
-				termui.NewCol(1.0/4, ui.TotalPowerChart),
+			row(1.0/4,
+				col(1.0/4, ui.gpuGauge),
+				col(1.0/4, ui.aneGauge),
+				col(1.0/4, ui.PowerChart),
+				col(1.0/4, ui.TotalPowerChart),
 			),
-			termui.NewRow(1.0/4,
-				termui.NewCol(3.0/6, ui.memoryGauge),
-				termui.NewCol(1.0/6, ui.modelText),
-				termui.NewCol(2.0/6, ui.NetworkInfo),
+			row(1.0/4,
+				col(3.0/6, ui.memoryGauge),
+				col(1.0/6, ui.modelText),
+				col(2.0/6, ui.NetworkInfo, ui.DerivedInfo),
 			),
 		)
-		termWidth, termHeight := termui.TerminalDimensions()
-		newGrid.SetRect(0, 0, termWidth, termHeight)
-		ui.grid = newGrid
-		ui.currentGridLayout = AlternativeGridLayout
-	} else {
-		newGrid := termui.NewGrid()
-		newGrid.Set(
-			termui.NewRow(1.0/2,
-				termui.NewCol(1.0/2, termui.NewRow(1.0/2, ui.cpu1Gauge), termui.NewCol(1.0, termui.NewRow(1.0, ui.cpu2Gauge))),
-				termui.NewCol(1.0/2, termui.NewRow(1.0/2, ui.gpuGauge), termui.NewCol(1.0, termui.NewRow(1.0, ui.aneGauge))),
+	default:
+		ui.grid = ui.buildGrid(
+			row(1.0/2,
+				col(1.0/2, ui.cpu1Gauge, ui.cpu2Gauge),
+				col(1.0/2, ui.gpuGauge, ui.aneGauge),
 			),
-			termui.NewRow(1.0/4,
-				termui.NewCol(1.0/4, ui.modelText),
-				termui.NewCol(1.0/4, ui.NetworkInfo),
-				termui.NewCol(1.0/4, ui.PowerChart),
-				termui.NewCol(1.0/4, ui.TotalPowerChart),
+			row(1.0/4,
+				col(1.0/6, ui.modelText),
+				col(1.0/3, ui.NetworkInfo),
+				col(1.0/4, ui.PowerChart),
+				col(1.0/4, ui.TotalPowerChart),
 			),
-			termui.NewRow(1.0/4,
-				termui.NewCol(1.0, ui.memoryGauge),
+			row(1.0/4,
+				col(2.0/3, ui.memoryGauge),
+				col(1.0/3, ui.DerivedInfo),
 			),
 		)
-		termWidth, termHeight := termui.TerminalDimensions()
-		newGrid.SetRect(0, 0, termWidth, termHeight)
-		ui.grid = newGrid
+	}
+}
+
+func (ui *UI) switchGridLayout() {
+	if ui.currentGridLayout == DefaultGridLayout {
+		ui.currentGridLayout = AlternativeGridLayout
+	} else {
 		ui.currentGridLayout = DefaultGridLayout
 	}
+	ui.setupGrid()
+}
+
+// hideDisabledWidgets hides the widgets whose powermetrics sampler is off.
+func (ui *UI) hideDisabledWidgets() {
+	ui.hidden = make(map[termui.Drawable]bool)
+	cpuPower := ui.collectorOpts.Enabled("cpu_power")
+	gpuPower := ui.collectorOpts.Enabled("gpu_power")
+	if !cpuPower {
+		ui.hidden[ui.cpu1Gauge] = true
+		ui.hidden[ui.cpu2Gauge] = true
+		ui.hidden[ui.aneGauge] = true
+		ui.hidden[ui.TotalPowerChart] = true
+	}
+	if !gpuPower {
+		ui.hidden[ui.gpuGauge] = true
+	}
+	if !cpuPower && !gpuPower {
+		ui.hidden[ui.PowerChart] = true
+	}
+	if !ui.collectorOpts.Enabled("network") && !ui.collectorOpts.Enabled("disk") {
+		ui.hidden[ui.NetworkInfo] = true
+	}
+	if len(ui.collectorOpts.ProcessStats) == 0 {
+		ui.hidden[ui.ProcessInfo] = true
+	}
+	if len(ui.collectorOpts.Derived) == 0 {
+		ui.hidden[ui.DerivedInfo] = true
+	}
+	if ui.linux() {
+		// There is no neural engine to show.
+		ui.hidden[ui.aneGauge] = true
+	}
+}
+
+// linux reports whether metrics come from the linux backend.
+func (ui *UI) linux() bool {
+	return ui.collectorOpts.Backend == collector.BackendLinux
 }
 
 func (ui *UI) setupWidgets() {
@@ -149,17 +255,23 @@ func (ui *UI) setupWidgets() {
 	}
 	eCoreCount := appleSiliconModel.ECoreCount
 	pCoreCount := appleSiliconModel.PCoreCount
-	gpuCoreCount := appleSiliconModel.GpuCoreCount
-	if gpuCoreCount == "" {
-		gpuCoreCount = "?"
+	gpuCoreCount := "?"
+	if appleSiliconModel.GpuCoreCount > 0 {
+		gpuCoreCount = strconv.Itoa(appleSiliconModel.GpuCoreCount)
 	}
-	ui.modelText.Text = fmt.Sprintf("%s\nTotal Cores: %d\nE-Cores: %d\nP-Cores: %d\nGPU Cores: %s",
+	ui.modelInfo = fmt.Sprintf("%s\nTotal Cores: %d\nE-Cores: %d\nP-Cores: %d\nGPU Cores: %s",
 		modelName,
 		eCoreCount+pCoreCount,
 		eCoreCount,
 		pCoreCount,
 		gpuCoreCount,
 	)
+	if memory := appleSiliconModel.MemoryBytes; memory > 0 {
+		ui.modelInfo += fmt.Sprintf("\nMemory: %d GB", (memory+1<<29)>>30)
+		if bw := appleSiliconModel.MemoryBw; bw > 0 {
+			ui.modelInfo += fmt.Sprintf(" @ %g GB/s", bw)
+		}
+	}
 	logrus.Printf("Model: %s\nE-Core Count: %d\nP-Core Count: %d\nGPU Core Count: %s",
 		modelName,
 		eCoreCount,
@@ -196,6 +308,9 @@ func (ui *UI) setupWidgets() {
 	ui.ProcessInfo = widgets.NewParagraph()
 	ui.ProcessInfo.Title = "Process Info"
 
+	ui.DerivedInfo = widgets.NewParagraph()
+	ui.DerivedInfo.Title = "Derived Metrics"
+
 	ui.TotalPowerChart = widgets.NewBarChart()
 	ui.TotalPowerChart.Title = "~ W Total Power"
 	ui.TotalPowerChart.SetRect(50, 0, 75, 10)
@@ -210,6 +325,8 @@ func (ui *UI) setupWidgets() {
 	ui.memoryGauge.Title = "Memory Usage"
 	ui.memoryGauge.Percent = 0
 	ui.memoryGauge.BarColor = termui.ColorCyan
+
+	ui.updateStatusUI()
 }
 
 func (ui *UI) updateCPUUI(cpuMetrics parser.CPUMetrics) {
@@ -219,7 +336,7 @@ func (ui *UI) updateCPUUI(cpuMetrics parser.CPUMetrics) {
 	ui.cpu2Gauge.Title = fmt.Sprintf("P-CPU Usage: %d%% @ %d MHz", cpuMetrics.PClusterActive, cpuMetrics.PClusterFreqMHz)
 	ui.cpu2Gauge.Percent = cpuMetrics.PClusterActive
 
-	aneUtil := int(cpuMetrics.ANEW * 100 / 8.0)
+	aneUtil := int(cpuMetrics.ANEW * 100 / ui.aneMaxPower())
 
 	ui.aneGauge.Title = fmt.Sprintf("ANE Usage: %d%% @ %.1f W", aneUtil, cpuMetrics.ANEW)
 	ui.aneGauge.Percent = aneUtil
@@ -228,9 +345,77 @@ func (ui *UI) updateCPUUI(cpuMetrics parser.CPUMetrics) {
 
 	ui.PowerChart.Title = fmt.Sprintf("%.1f W CPU - %.1f W GPU", cpuMetrics.CPUW, cpuMetrics.GPUW)
 	ui.PowerChart.Text = fmt.Sprintf("CPU Power: %.1f W\nGPU Power: %.1f W\nANE Power: %.1f W\nTotal Power: %.1f W", cpuMetrics.CPUW, cpuMetrics.GPUW, cpuMetrics.ANEW, cpuMetrics.PackageW)
+}
 
-	memoryMetrics := parser.GetMemoryMetrics()
+// aneMaxPower returns the power of the ANE at full load, assuming 8 W for
+// chips missing from the spec table.
+func (ui *UI) aneMaxPower() float64 {
+	if ui.socInfo.AneMaxPower > 0 {
+		return ui.socInfo.AneMaxPower
+	}
+	return 8
+}
 
+// updateCoreUI fills the CPU gauges from per-core usage when powermetrics
+// is unavailable. Apple Silicon numbers its efficiency cores first.
+func (ui *UI) updateCoreUI(usage []float64) {
+	if len(usage) == 0 {
+		return
+	}
+	average := func(values []float64) int {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return int(sum / float64(len(values)))
+	}
+	eCores := ui.socInfo.ECoreCount
+	if eCores <= 0 || eCores >= len(usage) {
+		ui.cpu1Gauge.Title = fmt.Sprintf("CPU Usage: %d%% (%d cores)", average(usage), len(usage))
+		ui.cpu1Gauge.Percent = average(usage)
+		busiest := int(slices.Max(usage))
+		ui.cpu2Gauge.Title = fmt.Sprintf("Busiest Core: %d%%", busiest)
+		ui.cpu2Gauge.Percent = busiest
+		return
+	}
+	ui.cpu1Gauge.Title = fmt.Sprintf("E-CPU Usage: %d%%", average(usage[:eCores]))
+	ui.cpu1Gauge.Percent = average(usage[:eCores])
+	ui.cpu2Gauge.Title = fmt.Sprintf("P-CPU Usage: %d%%", average(usage[eCores:]))
+	ui.cpu2Gauge.Percent = average(usage[eCores:])
+}
+
+// updateLinuxPowerUI shows the RAPL power rails and the hottest sensor. The
+// uncore rail, reported as GPU power, covers the integrated GPU.
+func (ui *UI) updateLinuxPowerUI(snapshot *collector.Snapshot) {
+	cpu := snapshot.CPU
+	hottest := 0.0
+	for _, t := range snapshot.Temperatures {
+		hottest = max(hottest, t)
+	}
+	ui.TotalPowerChart.Title = fmt.Sprintf("%.1f W Package Power", cpu.PackageW)
+	ui.PowerChart.Title = fmt.Sprintf("%.1f W CPU - %.1f W Uncore", cpu.CPUW, cpu.GPUW)
+	ui.PowerChart.Text = fmt.Sprintf("CPU Power: %.1f W\nUncore Power: %.1f W\nDRAM Power: %.1f W\nPackage Power: %.1f W\nHottest Sensor: %.1f °C", cpu.CPUW, cpu.GPUW, cpu.DRAMW, cpu.PackageW, hottest)
+}
+
+// markUnavailable labels the widgets that need powermetrics when running
+// without root.
+func (ui *UI) markUnavailable() {
+	const unavailable = "unavailable without sudo"
+	ui.gpuGauge.Title = "GPU Usage: " + unavailable
+	ui.aneGauge.Title = "ANE Usage: " + unavailable
+	ui.PowerChart.Title = "Power Usage: " + unavailable
+	ui.PowerChart.Text = "Power, frequencies, GPU and ANE\nare read from powermetrics,\nwhich needs root.\nRun sudo mactop to see them."
+	ui.TotalPowerChart.Title = "Total Power: " + unavailable
+	for _, b := range []*termui.Block{&ui.gpuGauge.Block, &ui.aneGauge.Block, &ui.PowerChart.Block, &ui.TotalPowerChart.Block} {
+		b.TitleStyle = termui.NewStyle(staleColor)
+		b.BorderStyle = termui.NewStyle(staleColor)
+	}
+}
+
+func (ui *UI) updateMemoryUI(memoryMetrics parser.MemoryMetrics) {
+	if memoryMetrics.Total == 0 {
+		return
+	}
 	ui.memoryGauge.Title = fmt.Sprintf("Memory Usage: %.2f GB / %.2f GB (Swap: %.2f/%.2f GB)", float64(memoryMetrics.Used)/1024/1024/1024, float64(memoryMetrics.Total)/1024/1024/1024, float64(memoryMetrics.SwapUsed)/1024/1024/1024, float64(memoryMetrics.SwapTotal)/1024/1024/1024)
 	ui.memoryGauge.Percent = int((float64(memoryMetrics.Used) / float64(memoryMetrics.Total)) * 100)
 }
@@ -240,12 +425,17 @@ func (ui *UI) updateGPUUI(gpuMetrics parser.GPUMetrics) {
 	ui.gpuGauge.Percent = int(gpuMetrics.Active)
 }
 
-func (ui *UI) updateNetDiskUI(netdiskMetrics parser.NetDiskMetrics) {
+func (ui *UI) updateNetDiskUI(netdiskMetrics parser.NetDiskMetrics, diskSpace parser.DiskSpaceMetrics) {
 	ui.NetworkInfo.Text = fmt.Sprintf("Out: %.1f packets/s, %.1f bytes/s\nIn: %.1f packets/s, %.1f bytes/s\nRead: %.1f ops/s, %.1f KBytes/s\nWrite: %.1f ops/s, %.1f KBytes/s", netdiskMetrics.OutPacketsPerSec, netdiskMetrics.OutBytesPerSec, netdiskMetrics.InPacketsPerSec, netdiskMetrics.InBytesPerSec, netdiskMetrics.ReadOpsPerSec, netdiskMetrics.ReadKBytesPerSec, netdiskMetrics.WriteOpsPerSec, netdiskMetrics.WriteKBytesPerSec)
+	if diskSpace.Total > 0 {
+		ui.NetworkInfo.Text += fmt.Sprintf("\nDisk %s: %.1f GB / %.1f GB", diskSpace.Path, float64(diskSpace.Used)/1024/1024/1024, float64(diskSpace.Total)/1024/1024/1024)
+	}
 }
 
 func (ui *UI) updateProcessUI(processMetrics []parser.ProcessMetrics) {
 	ui.ProcessInfo.Text = ""
+	// The slice is shared with other subscribers, sort a copy.
+	processMetrics = slices.Clone(processMetrics)
 	sort.Slice(processMetrics, func(i, j int) bool {
 		return processMetrics[i].CPUUsage > processMetrics[j].CPUUsage
 	})
@@ -258,25 +448,143 @@ func (ui *UI) updateProcessUI(processMetrics []parser.ProcessMetrics) {
 	}
 }
 
-func (ui *UI) updateTotalPowerChart(newPowerValue float64) {
-	currentTime := time.Now()
-	ui.powerValues = append(ui.powerValues, newPowerValue)
-	if currentTime.Sub(ui.lastUpdateTime) >= 2*time.Second {
-		var sum float64
-		for _, value := range ui.powerValues {
-			sum += value
+// updateDerivedUI lists the derived metrics in the order they are
+// configured. Metrics that could not be computed show as "-".
+func (ui *UI) updateDerivedUI(derived map[string]float64) {
+	var lines []string
+	for _, d := range ui.collectorOpts.Derived {
+		value := "-"
+		if v, ok := derived[d.Name]; ok {
+			value = strings.TrimSpace(fmt.Sprintf("%.3g %s", v, d.Unit))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", d.Name, value))
+	}
+	ui.DerivedInfo.Text = strings.Join(lines, "\n")
+}
+
+func (ui *UI) updateHealthUI(health collector.Health) {
+	ui.lastHealth = health
+	ui.updateStatusUI()
+}
+
+// updateInterval adapts rendering and power chart bucketing to a changed
+// sample interval.
+func (ui *UI) updateInterval(interval int) {
+	if interval == ui.interval {
+		return
+	}
+	ui.interval = interval
+	ui.needRender.SetGracePeriod(time.Duration(interval/2) * time.Millisecond)
+	ui.updateStatusUI()
+}
+
+func (ui *UI) updateStatusUI() {
+	health := ui.lastHealth
+	if ui.linux() {
+		ui.modelText.Text = fmt.Sprintf("%s\nInterval: %d ms\nBackend: procfs and sysfs", ui.modelInfo, ui.interval)
+		return
+	}
+	status := fmt.Sprintf("Interval: %d ms\npowermetrics: %s", ui.interval, health.State)
+	if health.Restarts > 0 {
+		status += fmt.Sprintf(" (%d restarts)", health.Restarts)
+	}
+	if dropped := ui.snapshots.Dropped(); dropped > 0 {
+		status += fmt.Sprintf("\n%d samples dropped", dropped)
+	}
+	if health.State != collector.StateRunning && health.LastError != "" {
+		status += "\n" + health.LastError
+	}
+	ui.modelText.Text = ui.modelInfo + "\n" + status
+}
+
+// updateTotalPowerChart shows the last 25 buckets of package power from
+// the history, newest first.
+func (ui *UI) updateTotalPowerChart() {
+	const bars = 25
+	bucket := max(2*time.Second, time.Duration(ui.interval)*time.Millisecond)
+	now := time.Now()
+	points := ui.history.Query("PackageW", now.Add(-bars*bucket), bucket)
+
+	// Buckets without samples, including gaps, are shown as empty bars
+	// rather than being skipped.
+	avg := make(map[time.Time]float64, len(points))
+	for _, p := range points {
+		if !p.Gap() {
+			avg[p.Time.Truncate(bucket)] = p.Avg
+		}
+	}
+	data := make([]float64, 0, bars)
+	for t := now.Truncate(bucket); len(data) < bars; t = t.Add(-bucket) {
+		data = append(data, math.Round(avg[t]))
+	}
+	ui.TotalPowerChart.Data = data
+}
+
+// updateStaleUI greys out and marks the widgets of every stale source.
+func (ui *UI) updateStaleUI(snapshot *collector.Snapshot) {
+	cpuSource := collector.SourcePower
+	if ui.collectorOpts.Unprivileged || ui.linux() {
+		cpuSource = collector.SourceCPU
+	}
+	groups := map[collector.Source][]*termui.Block{
+		collector.SourcePower: {
+			&ui.gpuGauge.Block, &ui.aneGauge.Block, &ui.PowerChart.Block, &ui.TotalPowerChart.Block,
+		},
+		collector.SourceNetDisk:   {&ui.NetworkInfo.Block},
+		collector.SourceMemory:    {&ui.memoryGauge.Block},
+		collector.SourceProcesses: {&ui.ProcessInfo.Block},
+	}
+	if ui.collectorOpts.Unprivileged {
+		// These stay marked unavailable.
+		delete(groups, collector.SourcePower)
+	}
+	groups[cpuSource] = append(groups[cpuSource], &ui.cpu1Gauge.Block, &ui.cpu2Gauge.Block)
+	for source, blocks := range groups {
+		stale := snapshot.IsStale(source)
+		for _, b := range blocks {
+			b.Title = strings.TrimSuffix(b.Title, staleSuffix)
+			if stale {
+				b.Title += staleSuffix
+				b.TitleStyle = termui.NewStyle(staleColor)
+				b.BorderStyle = termui.NewStyle(staleColor)
+			} else {
+				b.TitleStyle = termui.Theme.Block.Title
+				b.BorderStyle = termui.Theme.Block.Border
+			}
+		}
+	}
+}
+
+// stepInterval moves the sample interval one step shorter (-1) or longer
+// (+1). The new interval shows up once the first sample taken with it
+// arrives.
+func (ui *UI) stepInterval(direction int) {
+	current := ui.controller.Interval()
+	next := current
+	if direction > 0 {
+		for _, step := range intervalSteps {
+			if step > current {
+				next = step
+				break
+			}
 		}
-		averagePower := sum / float64(len(ui.powerValues))
-		averagePower = math.Round(averagePower)
-		ui.TotalPowerChart.Data = append([]float64{averagePower}, ui.TotalPowerChart.Data...)
-		if len(ui.TotalPowerChart.Data) > 25 {
-			ui.TotalPowerChart.Data = ui.TotalPowerChart.Data[:25]
+	} else {
+		for i := len(intervalSteps) - 1; i >= 0; i-- {
+			if intervalSteps[i] < current {
+				next = intervalSteps[i]
+				break
+			}
+		}
+	}
+	if next != current {
+		if err := ui.controller.SetInterval(next); err != nil {
+			logrus.Errorf("failed to change interval: %v", err)
 		}
-		ui.powerValues = nil
-		ui.lastUpdateTime = currentTime
 	}
 }
 
+// Render runs the UI until the user quits or a signal arrives, and restores
+// the terminal before returning.
 func (ui *UI) Render() {
 	var err = termui.Init()
 	if err != nil {
@@ -325,36 +633,66 @@ func (ui *UI) Render() {
 		ui.setupWidgets()
 	}
 
+	if ui.collectorOpts.Unprivileged {
+		ui.markUnavailable()
+	}
+	ui.hideDisabledWidgets()
 	ui.setupGrid()
-
-	termWidth, termHeight := termui.TerminalDimensions()
-	ui.grid.SetRect(0, 0, termWidth, termHeight)
 	termui.Render(ui.grid)
 
-	needRender := event_throttler.NewEventThrottler(time.Duration(ui.updateInterval/2) * time.Millisecond)
+	ui.needRender = event_throttler.NewEventThrottler(time.Duration(ui.interval/2) * time.Millisecond)
+	needRender := ui.needRender
+
+	// stop ends the render loop, which has to be done before the terminal
+	// is restored.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	exit := func() {
+		close(stop)
+		<-stopped
+		termui.Close()
+	}
 
 	go func() {
+		defer close(stopped)
+		snapshotC, healthC := ui.snapshots.C, ui.health.C
 		for {
 			select {
-			case cpuMetrics := <-ui.cpuMetricsChan:
-				ui.updateCPUUI(cpuMetrics)
-				ui.updateTotalPowerChart(cpuMetrics.PackageW)
-				needRender.Notify()
-			case gpuMetrics := <-ui.gpuMetricsChan:
-				ui.updateGPUUI(gpuMetrics)
+			case snapshot, ok := <-snapshotC:
+				if !ok {
+					snapshotC = nil
+					continue
+				}
+				ui.updateInterval(snapshot.Interval)
+				switch {
+				case ui.collectorOpts.Unprivileged:
+					ui.updateCoreUI(snapshot.CoreUsage)
+				case ui.linux():
+					ui.updateCoreUI(snapshot.CoreUsage)
+					ui.updateLinuxPowerUI(&snapshot)
+					ui.updateTotalPowerChart()
+					ui.updateGPUUI(snapshot.GPU)
+				default:
+					ui.updateCPUUI(snapshot.CPU)
+					ui.updateTotalPowerChart()
+					ui.updateGPUUI(snapshot.GPU)
+				}
+				ui.updateMemoryUI(snapshot.Memory)
+				ui.updateNetDiskUI(snapshot.NetDisk, snapshot.DiskSpace)
+				ui.updateProcessUI(snapshot.Processes)
+				ui.updateDerivedUI(snapshot.Derived)
+				ui.updateStaleUI(&snapshot)
 				needRender.Notify()
-			case netdiskMetrics := <-ui.netDiskMetricsChan:
-				ui.updateNetDiskUI(netdiskMetrics)
-				needRender.Notify()
-			case processMetrics := <-ui.processMetricsChan:
-				ui.updateProcessUI(processMetrics)
+			case health, ok := <-healthC:
+				if !ok {
+					healthC = nil
+					continue
+				}
+				ui.updateHealthUI(health)
 				needRender.Notify()
 			case <-needRender.C:
 				termui.Render(ui.grid)
-			case <-ui.quit:
-				close(ui.done)
-				termui.Close()
-				os.Exit(0)
+			case <-stop:
 				return
 			}
 		}
@@ -367,14 +705,16 @@ func (ui *UI) Render() {
 		case e := <-uiEvents:
 			switch e.ID {
 			case "q", "<C-c>": // "q" or Ctrl+C to quit
-				close(ui.done)
-				termui.Close()
-				os.Exit(0)
+				exit()
 				return
 			case "<Resize>":
 				payload := e.Payload.(termui.Resize)
 				ui.grid.SetRect(0, 0, payload.Width, payload.Height)
 				termui.Render(ui.grid)
+			case "+", "=":
+				ui.stepInterval(1)
+			case "-", "_":
+				ui.stepInterval(-1)
 			case "r":
 				// refresh termui data
 				termWidth, termHeight := termui.TerminalDimensions()
@@ -382,16 +722,12 @@ func (ui *UI) Render() {
 				termui.Clear()
 				termui.Render(ui.grid)
 			case "l":
-				// Set the new grid's dimensions to match the terminal size
-				termWidth, termHeight := termui.TerminalDimensions()
-				ui.grid.SetRect(0, 0, termWidth, termHeight)
 				termui.Clear()
 				ui.switchGridLayout()
 				termui.Render(ui.grid)
 			}
-		case <-ui.done:
-			termui.Close()
-			os.Exit(0)
+		case <-ui.quit:
+			exit()
 			return
 		}
 	}